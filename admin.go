@@ -0,0 +1,458 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// adminRootName is the name of a LevelFilter at the root of the graph.
+	adminRootName = "root"
+	// adminLevelsPath is the resource that lists all level filters.
+	adminLevelsPath = "/levels"
+	// adminLoggersPath is the resource that lists all named loggers.
+	adminLoggersPath = "/loggers"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// AdminHandler is an http.Handler for inspecting and changing logging
+// while a program is running, for example from an operations dashboard.
+//
+// All responses are JSON. The following requests are supported, relative
+// to where the handler is mounted (use http.StripPrefix if necessary):
+//
+//	GET /               the filter graph, level filters and named loggers
+//	GET /levels         all level filters
+//	GET /levels/NAME    one level filter
+//	PUT /levels/NAME    change a level filter
+//	GET /loggers        all named loggers of Hierarchy
+//	GET /loggers/NAME   one named logger, "root" for the root logger
+//	PUT /loggers/NAME   change a named logger
+//
+// Level filters are found by walking the graph from Filter. They are named
+// by their path in the graph, like the keys of a configuration document
+// (for example "logger.filters[1]"), or "root" for the root itself.
+// Levels adds further filters under names of your choice.
+//
+// A PUT request takes an object with any of these keys:
+//
+//	threshold        the new threshold, for example "debug"
+//	sample_level     the lowest level for sampling, defaults to "debug"
+//	sample_rate      the fraction of records below the threshold to log
+//	sample_duration  how long to sample, for example "5m"
+//
+// Sampling is temporary and ends on its own, see LevelFilter.Sample.
+// A sample_rate of 0 ends it immediately.
+//
+// For named loggers, the threshold is set with NamedLogger.SetLevel, so it
+// is inherited by descendants, and "inherit": true resets it to the level
+// of the parent. Sampling only applies to the logger itself.
+//
+// AdminHandler has no access control of its own. Only expose it on
+// trusted networks, or wrap it in an authenticating handler.
+type AdminHandler struct {
+	// Filter is the root of the filter graph, for example a *Pipeline.
+	Filter Filter
+	// Levels are additional level filters, by name.
+	Levels map[string]*LevelFilter
+	// Hierarchy holds the named loggers, for example DefaultHierarchy().
+	Hierarchy *Hierarchy
+}
+
+// levelUpdate is the body of a PUT request.
+type levelUpdate struct {
+	Threshold      *Level   `json:"threshold"`
+	SampleLevel    *Level   `json:"sample_level"`
+	SampleRate     *float64 `json:"sample_rate"`
+	SampleDuration string   `json:"sample_duration"`
+	Inherit        bool     `json:"inherit"`
+	// duration is the parsed SampleDuration
+	duration time.Duration
+}
+
+func (handler *AdminHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	path := "/" + strings.Trim(request.URL.Path, "/")
+	walker := &graphWalker{levels: make(map[string]*LevelFilter)}
+	graph := walker.describe(handler.Filter, "", 0)
+	levels := handler.levels(walker.levels)
+
+	switch {
+	case path == "/":
+		if request.Method != http.MethodGet {
+			adminError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		described := map[string]interface{}{
+			"pipeline": graph,
+			"levels":   describeLevels(levels),
+		}
+		if handler.Hierarchy != nil {
+			described["loggers"] = describeLoggers(handler.Hierarchy)
+		}
+		adminResponse(writer, http.StatusOK, described)
+	case path == adminLevelsPath:
+		if request.Method != http.MethodGet {
+			adminError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		adminResponse(writer, http.StatusOK, describeLevels(levels))
+	case strings.HasPrefix(path, adminLevelsPath+"/"):
+		name := strings.TrimPrefix(path, adminLevelsPath+"/")
+		filter, ok := levels[name]
+		if !ok {
+			adminError(writer, http.StatusNotFound, "unknown level filter: "+name)
+			return
+		}
+		switch request.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			update, err := readLevelUpdate(request)
+			if err == nil && update.Inherit {
+				err = fmt.Errorf("only named loggers can inherit levels")
+			}
+			if err != nil {
+				adminError(writer, http.StatusBadRequest, err.Error())
+				return
+			}
+			if update.Threshold != nil {
+				filter.SetThreshold(*update.Threshold)
+			}
+			update.sample(filter)
+		default:
+			adminError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		adminResponse(writer, http.StatusOK, describeLevel(filter))
+	case handler.Hierarchy != nil && path == adminLoggersPath:
+		if request.Method != http.MethodGet {
+			adminError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		adminResponse(writer, http.StatusOK, describeLoggers(handler.Hierarchy))
+	case handler.Hierarchy != nil && strings.HasPrefix(path, adminLoggersPath+"/"):
+		name := strings.TrimPrefix(path, adminLoggersPath+"/")
+		if name == adminRootName {
+			name = ""
+		}
+		logger, ok := handler.findLogger(name)
+		if !ok {
+			adminError(writer, http.StatusNotFound, "unknown logger: "+name)
+			return
+		}
+		switch request.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			update, err := readLevelUpdate(request)
+			if err == nil && update.Inherit && update.Threshold != nil {
+				err = fmt.Errorf("threshold and inherit are mutually exclusive")
+			}
+			if err != nil {
+				adminError(writer, http.StatusBadRequest, err.Error())
+				return
+			}
+			if update.Threshold != nil {
+				logger.SetLevel(*update.Threshold)
+			} else if update.Inherit {
+				logger.ResetLevel()
+			}
+			update.sample(logger.levels)
+		default:
+			adminError(writer, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		adminResponse(writer, http.StatusOK, describeLogger(logger))
+	default:
+		adminError(writer, http.StatusNotFound, "not found")
+	}
+}
+
+// levels combines the discovered level filters with the configured ones.
+// Configured names take precedence, and filters that have a configured
+// name are not listed again under their path.
+func (handler *AdminHandler) levels(discovered map[string]*LevelFilter) map[string]*LevelFilter {
+	levels := make(map[string]*LevelFilter, len(discovered)+len(handler.Levels))
+	named := make(map[*LevelFilter]bool, len(handler.Levels))
+	for name, filter := range handler.Levels {
+		levels[name] = filter
+		named[filter] = true
+	}
+	for name, filter := range discovered {
+		if _, ok := levels[name]; !ok && !named[filter] {
+			levels[name] = filter
+		}
+	}
+	return levels
+}
+
+// findLogger looks up an existing named logger.
+// Loggers are not created on request, so typos do not pollute the hierarchy.
+func (handler *AdminHandler) findLogger(name string) (*NamedLogger, bool) {
+	if name == "" {
+		return handler.Hierarchy.Root(), true
+	}
+	handler.Hierarchy.mutex.Lock()
+	defer handler.Hierarchy.mutex.Unlock()
+	logger, ok := handler.Hierarchy.loggers[name]
+	return logger, ok
+}
+
+// readLevelUpdate reads and validates the body of a PUT request.
+func readLevelUpdate(request *http.Request) (*levelUpdate, error) {
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	update := &levelUpdate{}
+	if err := decoder.Decode(update); err != nil {
+		return nil, fmt.Errorf("invalid request: %v", err)
+	}
+	if update.SampleDuration != "" {
+		var err error
+		if update.duration, err = time.ParseDuration(update.SampleDuration); err != nil {
+			return nil, fmt.Errorf("invalid sample_duration: %s", update.SampleDuration)
+		}
+	}
+	if update.SampleRate != nil {
+		rate := *update.SampleRate
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("sample_rate must be between 0 and 1")
+		}
+		if rate > 0 && update.duration <= 0 {
+			return nil, fmt.Errorf("sampling requires a sample_duration")
+		}
+	} else if update.SampleLevel != nil || update.duration != 0 {
+		return nil, fmt.Errorf("sampling requires a sample_rate")
+	}
+	return update, nil
+}
+
+// sample applies the sampling settings of an update, if there are any.
+func (update *levelUpdate) sample(filter *LevelFilter) {
+	if update.SampleRate != nil {
+		level := LevelDebug
+		if update.SampleLevel != nil {
+			level = *update.SampleLevel
+		}
+		filter.Sample(level, *update.SampleRate, update.duration)
+	}
+}
+
+func describeLevels(levels map[string]*LevelFilter) map[string]interface{} {
+	described := make(map[string]interface{}, len(levels))
+	for name, filter := range levels {
+		described[name] = describeLevel(filter)
+	}
+	return described
+}
+
+func describeLevel(filter *LevelFilter) map[string]interface{} {
+	described := map[string]interface{}{
+		"threshold": filter.CurrentThreshold(),
+		"dropped":   filter.Dropped(),
+	}
+	if level, rate, until, ok := filter.Sampling(); ok {
+		described["sampling"] = map[string]interface{}{
+			"level": level,
+			"rate":  rate,
+			"until": until,
+		}
+	}
+	return described
+}
+
+func describeLoggers(hierarchy *Hierarchy) map[string]interface{} {
+	loggers := hierarchy.Loggers()
+	described := make(map[string]interface{}, len(loggers))
+	for _, logger := range loggers {
+		described[stringOrDefault(logger.Name(), adminRootName)] = describeLogger(logger)
+	}
+	return described
+}
+
+func describeLogger(logger *NamedLogger) map[string]interface{} {
+	described := describeLevel(logger.levels)
+	_, explicit := logger.Level()
+	described["inherited"] = !explicit
+	return described
+}
+
+func adminResponse(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func adminError(writer http.ResponseWriter, status int, message string) {
+	adminResponse(writer, status, map[string]interface{}{
+		"error": message,
+	})
+}
+
+// graphWalker describes a filter graph and collects its level filters.
+type graphWalker struct {
+	levels map[string]*LevelFilter
+}
+
+// describe converts a component of the graph into a JSON-compatible
+// description of its type and exported settings.
+func (walker *graphWalker) describe(v interface{}, path string, depth int) interface{} {
+	if v == nil || depth > maxJsonDepth {
+		return nil
+	}
+	switch t := v.(type) {
+	case *Pipeline:
+		// a pipeline is transparent, its graph keeps the path
+		described := map[string]interface{}{
+			"type":     "Pipeline",
+			"override": t.overridden(),
+		}
+		if filter := t.Filter(); filter != nil {
+			described["filter"] = walker.describe(filter, path, depth+1)
+		}
+		return described
+	case *LevelFilter:
+		walker.levels[stringOrDefault(path, adminRootName)] = t
+	case *os.File:
+		return map[string]interface{}{
+			"type": "File",
+			"name": t.Name(),
+		}
+	}
+
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if scalar, ok := describeScalar(value); ok {
+		return scalar
+	}
+	described := map[string]interface{}{
+		"type": value.Type().Name(),
+	}
+	if value.Kind() == reflect.Struct {
+		walker.describeFields(described, value, path, depth)
+	}
+	if level, ok := v.(*LevelFilter); ok {
+		for k, e := range describeLevel(level) {
+			described[k] = e
+		}
+	}
+	return described
+}
+
+// describeFields adds the exported fields of a struct to a description.
+// Fields of embedded structs are added as if they were part of the struct.
+func (walker *graphWalker) describeFields(described map[string]interface{}, value reflect.Value, path string, depth int) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			walker.describeFields(described, value.Field(i), path, depth)
+			continue
+		}
+		key := snakeCase(field.Name)
+		if e := walker.describeValue(value.Field(i), joinConfigPath(path, key), depth+1); e != nil {
+			described[key] = e
+		}
+	}
+}
+
+// describeValue describes a field or list element.
+// Maps and functions are left out, as they may contain anything.
+func (walker *graphWalker) describeValue(value reflect.Value, path string, depth int) interface{} {
+	if scalar, ok := describeScalar(value); ok {
+		return scalar
+	}
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return walker.describe(value.Interface(), path, depth)
+	case reflect.Struct:
+		return walker.describe(value.Interface(), path, depth)
+	case reflect.Slice, reflect.Array:
+		if value.Len() == 0 {
+			return nil
+		}
+		list := make([]interface{}, value.Len())
+		for i := range list {
+			list[i] = walker.describeValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i), depth+1)
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+// describeScalar returns simple values as they are.
+func describeScalar(value reflect.Value) (interface{}, bool) {
+	if value.Type() == durationType {
+		return value.Interface().(time.Duration).String(), true
+	}
+	switch value.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Interface(), true
+	default:
+		return nil, false
+	}
+}
+
+// snakeCase converts a Go field name like MaxColumnWidth or UTC to the
+// style used in configuration documents: max_column_width, utc
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var snake []rune
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+				snake = append(snake, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		snake = append(snake, r)
+	}
+	return string(snake)
+}
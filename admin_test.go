@@ -0,0 +1,169 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, handler http.Handler, method string, path string, body string) (int, map[string]interface{}) {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	response, _ := ioutil.ReadAll(recorder.Body)
+	var result map[string]interface{}
+	if err := json.Unmarshal(response, &result); err != nil {
+		t.Errorf("%s %s: invalid response: %s", method, path, response)
+	}
+	return recorder.Code, result
+}
+
+func TestAdminHandler(t *testing.T) {
+	debug := &LevelFilter{Threshold: LevelInfo}
+	pipeline := NewPipeline(&MultiFilter{
+		Filters: []Filter{
+			&AddTimeFilter{UTC: true},
+		},
+		Logger: &LevelFilter{
+			Threshold: LevelWarn,
+			Logger: &Logger{
+				Formatter: &JsonFormatter{},
+				Sink:      ioutil.Discard,
+			},
+		},
+	})
+	handler := &AdminHandler{
+		Filter: pipeline,
+		Levels: map[string]*LevelFilter{"debug": debug},
+	}
+
+	c01, r01 := adminRequest(t, handler, http.MethodGet, "/", "")
+	if c01 != http.StatusOK {
+		t.Fatalf("t01: invalid status %d", c01)
+	}
+	p01 := r01["pipeline"].(map[string]interface{})
+	m01 := p01["filter"].(map[string]interface{})
+	if p01["type"] != "Pipeline" || m01["type"] != "MultiFilter" {
+		t.Errorf("t01: invalid graph: %v", p01)
+	}
+	if m01["filters"].([]interface{})[0].(map[string]interface{})["utc"] != true {
+		t.Errorf("t01: settings should be described: %v", m01["filters"])
+	}
+	l01 := r01["levels"].(map[string]interface{})
+	if len(l01) != 2 || l01["logger"].(map[string]interface{})["threshold"] != "warn" {
+		t.Errorf("t01: invalid levels: %v", l01)
+	}
+
+	pipeline.Printd(map[string]interface{}{LevelKey: LevelInfo})
+	c02, r02 := adminRequest(t, handler, http.MethodPut, "/levels/logger", `{"threshold": "info", "sample_rate": 0.5, "sample_duration": "5m"}`)
+	if c02 != http.StatusOK || r02["threshold"] != "info" || r02["dropped"] != 1.0 {
+		t.Errorf("t02: invalid response %d: %v", c02, r02)
+	}
+	if s02, ok := r02["sampling"].(map[string]interface{}); !ok || s02["level"] != "debug" || s02["rate"] != 0.5 {
+		t.Errorf("t02: sampling should be active: %v", r02)
+	}
+
+	c03, r03 := adminRequest(t, handler, http.MethodPut, "/levels/debug", `{"threshold": "trace"}`)
+	if c03 != http.StatusOK || r03["threshold"] != "trace" || debug.CurrentThreshold() != LevelTrace {
+		t.Errorf("t03: named levels should be changed: %d %v", c03, r03)
+	}
+
+	c04, r04 := adminRequest(t, handler, http.MethodPut, "/levels/logger", `{"threshold": "loud"}`)
+	if c04 != http.StatusBadRequest || r04["error"] == nil {
+		t.Errorf("t04: invalid levels should be rejected: %d %v", c04, r04)
+	}
+	c05, _ := adminRequest(t, handler, http.MethodPut, "/levels/logger", `{"sample_rate": 0.5}`)
+	if c05 != http.StatusBadRequest {
+		t.Errorf("t05: sampling without duration should be rejected: %d", c05)
+	}
+	c06, _ := adminRequest(t, handler, http.MethodGet, "/levels/nothing", "")
+	if c06 != http.StatusNotFound {
+		t.Errorf("t06: unknown levels should not be found: %d", c06)
+	}
+	c07, _ := adminRequest(t, handler, http.MethodDelete, "/levels", "")
+	if c07 != http.StatusMethodNotAllowed {
+		t.Errorf("t07: invalid method accepted: %d", c07)
+	}
+	c08, r08 := adminRequest(t, handler, http.MethodGet, "/levels/", "")
+	if c08 != http.StatusOK || len(r08) != 2 {
+		t.Errorf("t08: invalid level list %d: %v", c08, r08)
+	}
+}
+
+func TestAdminHandlerLoggers(t *testing.T) {
+	hierarchy := NewHierarchy(nil)
+	pool := hierarchy.Named("db.pool")
+	handler := &AdminHandler{
+		Hierarchy: hierarchy,
+	}
+
+	c01, r01 := adminRequest(t, handler, http.MethodGet, "/loggers", "")
+	if c01 != http.StatusOK || len(r01) != 3 || r01["root"] == nil || r01["db.pool"].(map[string]interface{})["inherited"] != true {
+		t.Errorf("t01: invalid loggers %d: %v", c01, r01)
+	}
+
+	c02, r02 := adminRequest(t, handler, http.MethodPut, "/loggers/db", `{"threshold": "error"}`)
+	if level, _ := pool.Level(); c02 != http.StatusOK || r02["inherited"] != false || level != LevelError {
+		t.Errorf("t02: the level should be set and inherited %d: %v", c02, r02)
+	}
+
+	c03, _ := adminRequest(t, handler, http.MethodPut, "/loggers/db", `{"inherit": true}`)
+	if level, _ := pool.Level(); c03 != http.StatusOK || level != LevelTrace {
+		t.Errorf("t03: the level should be reset %d: %v", c03, level)
+	}
+
+	c04, r04 := adminRequest(t, handler, http.MethodPut, "/loggers/root", `{"sample_rate": 1, "sample_duration": "1m", "threshold": "warn"}`)
+	if c04 != http.StatusOK || r04["sampling"] == nil || pool.LevelEnabled(LevelInfo) {
+		t.Errorf("t04: sampling should only apply to the logger itself %d: %v", c04, r04)
+	}
+
+	c05, _ := adminRequest(t, handler, http.MethodGet, "/loggers/db.missing", "")
+	c06, _ := adminRequest(t, handler, http.MethodPut, "/levels/root", `{"inherit": true}`)
+	if c05 != http.StatusNotFound || c06 != http.StatusNotFound {
+		t.Errorf("t05: unknown loggers should not be found: %d %d", c05, c06)
+	}
+	if len(hierarchy.Loggers()) != 3 {
+		t.Errorf("t05: requests should not create loggers")
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Logger":            "logger",
+		"UTC":               "utc",
+		"MaxColumnWidth":    "max_column_width",
+		"DisableHTMLEscape": "disable_html_escape",
+		"ContainerId":       "container_id",
+	} {
+		if r := snakeCase(name); r != expected {
+			t.Errorf("t01: %s converted to %s", name, r)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source for time-dependent filters.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// SystemClock is a Clock that returns the system time.
+type SystemClock struct{}
+
+func (clock SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a Clock that only advances when told to.
+// It is intended for tests that need deterministic timestamps.
+type FakeClock struct {
+	mutex   sync.Mutex
+	current time.Time
+}
+
+// NewFakeClock creates a FakeClock set to a specific time.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{
+		current: t,
+	}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.current
+}
+
+// Set changes the current time.
+func (clock *FakeClock) Set(t time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.current = t
+}
+
+// Advance moves the current time forward by d.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.current = clock.current.Add(d)
+}
+
+// clockOrDefault returns clock, or the system clock if it is nil.
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock{}
+	}
+	return clock
+}
+
+// isSystemClock checks if clock follows the system time, so real timers
+// can be used with it.
+func isSystemClock(clock Clock) bool {
+	switch clock.(type) {
+	case nil, SystemClock, *SystemClock:
+		return true
+	}
+	return false
+}
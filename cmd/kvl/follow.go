@@ -0,0 +1,177 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// tailChunkSize is the size of the blocks read when searching for
+	// the last lines of a file.
+	tailChunkSize = 64 * 1024
+)
+
+// followFile is an io.Reader that keeps reading a file as it grows, like
+// tail -F. When the file is rotated, the rest of the old file is read
+// before switching to the new one. When it is truncated, reading starts
+// over from the beginning.
+type followFile struct {
+	path     string
+	file     *os.File
+	offset   int64
+	interval time.Duration
+	done     <-chan struct{}
+}
+
+// openFollow opens a file for following, starting with the last lines of
+// the file, like tail -F. If lines is negative, the whole file is read.
+// Reading returns io.EOF once done is closed.
+func openFollow(path string, lines int, interval time.Duration, done <-chan struct{}) (*followFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var offset int64
+	if lines >= 0 {
+		if offset, err = tailOffset(file, lines); err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &followFile{
+		path:     path,
+		file:     file,
+		offset:   offset,
+		interval: interval,
+		done:     done,
+	}, nil
+}
+
+// tailOffset finds the start of the last lines of a file, by searching
+// backwards for newlines. A final line without a newline counts as a line.
+func tailOffset(file *os.File, lines int) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	end := info.Size()
+	if end == 0 || lines == 0 {
+		return end, nil
+	}
+	buffer := make([]byte, tailChunkSize)
+	// the newline that terminates the last line does not start a line
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, end-1); err != nil {
+		return 0, err
+	}
+	if last[0] == '\n' {
+		end--
+	}
+	position := end
+	for position > 0 {
+		size := int64(len(buffer))
+		if position < size {
+			size = position
+		}
+		position -= size
+		if _, err := file.ReadAt(buffer[:size], position); err != nil {
+			return 0, err
+		}
+		for i := size - 1; i >= 0; i-- {
+			if buffer[i] != '\n' {
+				continue
+			}
+			lines--
+			if lines == 0 {
+				return position + i + 1, nil
+			}
+		}
+	}
+	// the file is shorter than requested
+	return 0, nil
+}
+
+func (follow *followFile) Read(data []byte) (int, error) {
+	for {
+		n, err := follow.file.Read(data)
+		follow.offset += int64(n)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if follow.reopen() {
+			continue
+		}
+		select {
+		case <-follow.done:
+			return 0, io.EOF
+		case <-time.After(follow.interval):
+		}
+	}
+}
+
+// reopen checks if the file was rotated or truncated, and starts reading
+// the new contents. Returns true if there may be new data.
+func (follow *followFile) reopen() bool {
+	info, err := os.Stat(follow.path)
+	if err != nil {
+		// the file may be in the middle of being rotated
+		return false
+	}
+	current, err := follow.file.Stat()
+	if err != nil {
+		return false
+	}
+	if !os.SameFile(info, current) {
+		file, err := os.Open(follow.path)
+		if err != nil {
+			return false
+		}
+		follow.file.Close()
+		follow.file = file
+		follow.offset = 0
+		return true
+	}
+	if info.Size() < follow.offset {
+		if _, err := follow.file.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+		follow.offset = 0
+		return true
+	}
+	return false
+}
+
+func (follow *followFile) Close() error {
+	return follow.file.Close()
+}
@@ -0,0 +1,481 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Command kvl views, filters and converts logs written by kvl.
+//
+// It reads JSON, logfmt or console logs from files or standard input,
+// and writes them in a human-readable or machine-readable format:
+//
+//	kvl -level warn -grep timeout service.log
+//	kvl -f -keys request_id,status -since 15m /var/log/service.log
+//	kvl -format logfmt < service.json > service.log
+//
+// Run kvl -h for all options.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/onitake/kvl"
+)
+
+const (
+	// followInterval is how often followed files are checked for new data.
+	followInterval = 250 * time.Millisecond
+	colorReset     = "\x1b[0m"
+)
+
+var (
+	levelColors = map[kvl.Level]string{
+		kvl.LevelTrace: "\x1b[90m",
+		kvl.LevelDebug: "\x1b[90m",
+		kvl.LevelWarn:  "\x1b[33m",
+		kvl.LevelError: "\x1b[31m",
+	}
+	// timeLayouts are accepted by -since and -until, besides durations.
+	timeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+)
+
+// matchFlags collects repeated -match options.
+type matchFlags map[string]*regexp.Regexp
+
+func (matches matchFlags) String() string {
+	pairs := make([]string, 0, len(matches))
+	for k, re := range matches {
+		pairs = append(pairs, k+"="+re.String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (matches matchFlags) Set(value string) error {
+	equals := strings.IndexByte(value, '=')
+	if equals <= 0 {
+		return fmt.Errorf("expected key=regex")
+	}
+	re, err := regexp.Compile(value[equals+1:])
+	if err != nil {
+		return err
+	}
+	matches[value[:equals]] = re
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns the exit status.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("kvl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: kvl [options] [file ...]\n\nReads standard input if no files are given.\n\nOptions:\n")
+		flags.PrintDefaults()
+	}
+	input := flags.String("input", "auto", "input `format`: auto, json, logfmt or console")
+	format := flags.String("format", "console", "output `format`: console, pretty, json or logfmt")
+	color := flags.String("color", "auto", "colorize console output by level: auto, always or never")
+	keys := flags.String("keys", "", "comma-separated `list` of keys to show besides time, level and message")
+	columns := flags.Bool("columns", false, "align keys in columns in console output")
+	level := flags.String("level", "", "only show records with this `level` or above")
+	grep := flags.String("grep", "", "only show records whose message matches this `regex`")
+	since := flags.String("since", "", "only show records at or after this `time`, or this long ago, like 15m")
+	until := flags.String("until", "", "only show records before this `time`, or this long ago")
+	follow := flags.Bool("f", false, "follow files as they grow, including rotation")
+	lines := flags.Int("n", 10, "with -f, start with the last `lines` of each file, or the whole file if negative")
+	matches := matchFlags{}
+	flags.Var(matches, "match", "only show records where `key=regex` matches the value; may be repeated")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	now := time.Now()
+	selector := &selectFilter{
+		matches: matches,
+		stderr:  stderr,
+	}
+	var err error
+	if selector.since, err = parseTime(*since, now); err != nil {
+		return usageError(stderr, "-since", err)
+	}
+	if selector.until, err = parseTime(*until, now); err != nil {
+		return usageError(stderr, "-until", err)
+	}
+	if *grep != "" {
+		if selector.grep, err = regexp.Compile(*grep); err != nil {
+			return usageError(stderr, "-grep", err)
+		}
+	}
+	readFormat, ok := map[string]kvl.ReadFormat{
+		"auto":    kvl.ReadAuto,
+		"json":    kvl.ReadJson,
+		"logfmt":  kvl.ReadLogfmt,
+		"console": kvl.ReadConsole,
+	}[*input]
+	if !ok {
+		return usageError(stderr, "-input", fmt.Errorf("unknown format: %s", *input))
+	}
+	colorize := false
+	switch *color {
+	case "always":
+		colorize = true
+	case "auto":
+		colorize = isTerminal(stdout)
+	case "never":
+	default:
+		return usageError(stderr, "-color", fmt.Errorf("unknown mode: %s", *color))
+	}
+	output, err := newOutput(*format, colorize, *columns, stdout)
+	if err != nil {
+		return usageError(stderr, "-format", err)
+	}
+
+	// build the chain back to front
+	var filter kvl.Filter = output
+	if *keys != "" {
+		keep := []string{kvl.StdTimeKey, kvl.LevelKey, kvl.StdMessageKey}
+		for _, key := range strings.Split(*keys, ",") {
+			keep = append(keep, strings.TrimSpace(key))
+		}
+		filter = &kvl.MultiFilter{
+			Filters: []kvl.Filter{
+				&kvl.TransformFilter{
+					Rules: []kvl.TransformRule{{Op: kvl.TransformKeep, Keys: keep}},
+				},
+			},
+			Logger: filter,
+		}
+	}
+	selector.Logger = filter
+	filter = selector
+	if *level != "" {
+		threshold, err := kvl.ParseLevel(*level)
+		if err != nil {
+			return usageError(stderr, "-level", err)
+		}
+		filter = &kvl.LevelFilter{
+			Threshold: threshold,
+			Logger:    filter,
+		}
+	}
+	// followed files are read concurrently
+	filter = &lockedFilter{Logger: filter}
+
+	files := flags.Args()
+	if len(files) == 0 {
+		if *follow {
+			fmt.Fprintln(stderr, "kvl: -f requires files")
+			return 2
+		}
+		return readStream(kvl.NewReader(stdin), readFormat, "stdin", filter, stderr)
+	}
+	status := 0
+	var wait sync.WaitGroup
+	var statusMutex sync.Mutex
+	for _, path := range files {
+		var stream io.ReadCloser
+		var err error
+		if *follow {
+			stream, err = openFollow(path, *lines, followInterval, nil)
+		} else {
+			stream, err = os.Open(path)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "kvl: %v\n", err)
+			status = 1
+			continue
+		}
+		read := func(stream io.ReadCloser, path string) {
+			defer stream.Close()
+			if result := readStream(kvl.NewReader(stream), readFormat, path, filter, stderr); result != 0 {
+				statusMutex.Lock()
+				status = result
+				statusMutex.Unlock()
+			}
+		}
+		if *follow {
+			wait.Add(1)
+			go func(stream io.ReadCloser, path string) {
+				defer wait.Done()
+				read(stream, path)
+			}(stream, path)
+		} else {
+			read(stream, path)
+		}
+	}
+	wait.Wait()
+	return status
+}
+
+// readStream sends all records of a stream to a filter.
+// Lines that cannot be parsed are reported and skipped.
+func readStream(reader *kvl.Reader, format kvl.ReadFormat, name string, filter kvl.Filter, stderr io.Writer) int {
+	reader.Format = format
+	for {
+		kv, err := reader.Read()
+		switch err.(type) {
+		case nil:
+			filter.Printd(kv)
+		case *kvl.ReadError:
+			fmt.Fprintf(stderr, "kvl: %s: %v\n", name, err)
+		default:
+			if err == io.EOF {
+				return 0
+			}
+			fmt.Fprintf(stderr, "kvl: %s: %v\n", name, err)
+			return 1
+		}
+	}
+}
+
+func usageError(stderr io.Writer, option string, err error) int {
+	fmt.Fprintf(stderr, "kvl: invalid %s: %v\n", option, err)
+	return 2
+}
+
+// parseTime parses an absolute time or a duration before now.
+// The empty string results in the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("expected a time like 2006-01-02 15:04:05 or a duration like 15m: %s", value)
+}
+
+// isTerminal checks if output goes to a terminal.
+func isTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// newOutput creates the last stage of the chain.
+func newOutput(format string, colorize bool, columns bool, stdout io.Writer) (kvl.Filter, error) {
+	switch format {
+	case "console":
+		return &colorLogger{
+			Formatter: &kvl.ConsoleFormatter{
+				PrintTime: true,
+				PrintKeys: true,
+				SortKeys:  true,
+				Columns:   columns,
+			},
+			Sink:  stdout,
+			Color: colorize,
+		}, nil
+	case "pretty":
+		formatter := &kvl.PrettyFormatter{
+			ConsoleFormatter: kvl.ConsoleFormatter{
+				PrintTime: true,
+				PrintKeys: true,
+				SortKeys:  true,
+				Columns:   columns,
+			},
+			NoColor: !colorize,
+		}
+		return &startFilter{
+			Formatter: formatter,
+			Logger: &kvl.Logger{
+				Formatter: formatter,
+				Sink:      stdout,
+			},
+		}, nil
+	case "json":
+		return &kvl.Logger{
+			Formatter: &kvl.JsonFormatter{
+				JsonEncoder: kvl.JsonEncoder{
+					LeadingKeys: kvl.StdLeadingKeys,
+				},
+			},
+			Sink: stdout,
+		}, nil
+	case "logfmt":
+		return &kvl.Logger{
+			Formatter: &kvl.LogfmtFormatter{
+				LeadingKeys: kvl.StdLeadingKeys,
+			},
+			Sink: stdout,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// selectFilter passes on the records that match all conditions.
+type selectFilter struct {
+	matches matchFlags
+	grep    *regexp.Regexp
+	since   time.Time
+	until   time.Time
+	Logger  kvl.Filter
+	// stderr receives a warning when records without a usable time are
+	// dropped because of a time range.
+	stderr io.Writer
+	warned bool
+}
+
+func (filter *selectFilter) Printd(kv map[string]interface{}) {
+	if filter.grep != nil && !filter.grep.MatchString(fmt.Sprint(kv[kvl.StdMessageKey])) {
+		return
+	}
+	for k, re := range filter.matches {
+		v, ok := kv[k]
+		if !ok || !re.MatchString(fmt.Sprint(v)) {
+			return
+		}
+	}
+	if !filter.since.IsZero() || !filter.until.IsZero() {
+		t, ok := recordTime(kv[kvl.StdTimeKey])
+		if !ok {
+			// records without a time cannot be placed in the range
+			if !filter.warned {
+				fmt.Fprintf(filter.stderr, "kvl: skipping records without a usable %s, like: %v\n", kvl.StdTimeKey, kv[kvl.StdTimeKey])
+				filter.warned = true
+			}
+			return
+		}
+		if !filter.since.IsZero() && t.Before(filter.since) || !filter.until.IsZero() && !t.Before(filter.until) {
+			return
+		}
+	}
+	filter.Logger.Printd(kv)
+}
+
+// recordTime converts the time of a record. Besides time.Time, it accepts
+// Unix timestamps in seconds, milliseconds, microseconds or nanoseconds,
+// as numbers or strings, and guesses the unit from the magnitude.
+func recordTime(v interface{}) (time.Time, bool) {
+	var text string
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case json.Number:
+		text = t.String()
+	case string:
+		text = t
+	case int64:
+		text = strconv.FormatInt(t, 10)
+	case float64:
+		text = strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return time.Time{}, false
+	}
+	if epoch, err := strconv.ParseInt(text, 10, 64); err == nil {
+		switch {
+		case epoch < 1e11 && epoch > -1e11:
+			return time.Unix(epoch, 0), true
+		case epoch < 1e14 && epoch > -1e14:
+			return time.Unix(0, epoch*int64(time.Millisecond)), true
+		case epoch < 1e17 && epoch > -1e17:
+			return time.Unix(0, epoch*int64(time.Microsecond)), true
+		default:
+			return time.Unix(0, epoch), true
+		}
+	}
+	// fractional seconds
+	if epoch, err := strconv.ParseFloat(text, 64); err == nil && epoch < 1e11 && epoch > -1e11 {
+		return time.Unix(0, int64(epoch*1e9)), true
+	}
+	return time.Time{}, false
+}
+
+// startFilter makes pretty timestamps relative to the first record,
+// instead of the start of this program.
+type startFilter struct {
+	Formatter *kvl.PrettyFormatter
+	Logger    kvl.Filter
+}
+
+func (filter *startFilter) Printd(kv map[string]interface{}) {
+	if t, ok := kv[kvl.StdTimeKey].(time.Time); ok && filter.Formatter.Start.IsZero() {
+		filter.Formatter.Start = t
+	}
+	filter.Logger.Printd(kv)
+}
+
+// lockedFilter serializes records from several goroutines.
+type lockedFilter struct {
+	mutex  sync.Mutex
+	Logger kvl.Filter
+}
+
+func (filter *lockedFilter) Printd(kv map[string]interface{}) {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+	filter.Logger.Printd(kv)
+}
+
+// colorLogger formats records and colors each line according to its level.
+type colorLogger struct {
+	Formatter kvl.Formatter
+	Sink      io.Writer
+	Color     bool
+	buffer    bytes.Buffer
+}
+
+func (logger *colorLogger) Printd(kv map[string]interface{}) {
+	if !logger.Color {
+		logger.Formatter.Formatd(kv, logger.Sink)
+		return
+	}
+	level, err := kvl.ParseLevel(fmt.Sprint(kv[kvl.LevelKey]))
+	code, ok := levelColors[level]
+	if err != nil || !ok {
+		logger.Formatter.Formatd(kv, logger.Sink)
+		return
+	}
+	logger.buffer.Reset()
+	logger.Formatter.Formatd(kv, &logger.buffer)
+	line := bytes.TrimSuffix(logger.buffer.Bytes(), []byte{'\n'})
+	fmt.Fprintf(logger.Sink, "%s%s%s\n", code, line, colorReset)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInput = `{"time":"2024-01-02T03:04:05Z","level":"info","message":"hello","user":"bob","n":3}
+{"time":"2024-01-02T03:05:05Z","level":"error","message":"boom timeout","user":"alice"}
+not a record {
+time=2024-01-02T03:06:00Z level=warn message="disk full" user=carol
+`
+
+func runKvl(input string, args ...string) (int, string, string) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	status := run(args, strings.NewReader(input), stdout, stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	s01, o01, _ := runKvl(testInput, "-color", "never")
+	if s01 != 0 || o01 != "[2024-01-02 03:04:05] hello | level: info | n: 3 | user: bob\n[2024-01-02 03:05:05] boom timeout | level: error | user: alice\nnot a record {\n[2024-01-02 03:06:00] disk full | level: warn | user: carol\n" {
+		t.Errorf("t01: invalid console output: %d %q", s01, o01)
+	}
+	s02, o02, _ := runKvl(testInput, "-format", "logfmt", "-level", "warn", "-match", "user=^(alice|carol)$")
+	if s02 != 0 || o02 != "time=2024-01-02T03:05:05Z level=error message=\"boom timeout\" user=alice\ntime=2024-01-02T03:06:00Z level=warn message=\"disk full\" user=carol\n" {
+		t.Errorf("t02: invalid logfmt output: %d %q", s02, o02)
+	}
+	_, o03, _ := runKvl(testInput, "-format", "json", "-grep", "^boom")
+	if o03 != "{\"time\":\"2024-01-02T03:05:05Z\",\"level\":\"error\",\"message\":\"boom timeout\",\"user\":\"alice\"}\n" {
+		t.Errorf("t03: invalid json output: %q", o03)
+	}
+	_, o04, _ := runKvl(testInput, "-color", "always", "-keys", "n", "-since", "2024-01-02T03:05:00Z", "-until", "2024-01-02 03:06:00")
+	if o04 != "\x1b[31m[2024-01-02 03:05:05] boom timeout | level: error\x1b[0m\n" {
+		t.Errorf("t04: invalid colored output: %q", o04)
+	}
+	_, o05, _ := runKvl(testInput, "-format", "pretty", "-color", "never", "-match", "user=.")
+	if !strings.HasPrefix(o05, "     +0.000s INFO  hello\n") || !strings.Contains(o05, "    +60.000s ERROR boom timeout\n") {
+		t.Errorf("t05: pretty times should be relative to the first record: %q", o05)
+	}
+	_, o06, e06 := runKvl("{\"message\": \"unterminated\"\n", "-input", "json")
+	if o06 != "" || !strings.Contains(e06, "stdin") {
+		t.Errorf("t06: parse errors should be reported: %q %q", o06, e06)
+	}
+	if s07, _, e07 := runKvl("", "-level", "loud"); s07 != 2 || !strings.Contains(e07, "-level") {
+		t.Errorf("t07: invalid options should be reported: %d %q", s07, e07)
+	}
+	if s08, _, e08 := runKvl("", "-color", "never", filepath.Join(os.TempDir(), "kvl-does-not-exist.log")); s08 != 1 || e08 == "" {
+		t.Errorf("t08: missing files should fail: %d %q", s08, e08)
+	}
+	_, o09, _ := runKvl("{\"message\":\"hi\",\"count\":42,\"ratio\":0.5,\"list\":[1,2.5]}\n", "-format", "json")
+	if o09 != "{\"message\":\"hi\",\"count\":42,\"list\":[1,2.5],\"ratio\":0.5}\n" {
+		t.Errorf("t09: numbers should keep their type: %q", o09)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if t01, err := parseTime("15m", now); err != nil || !t01.Equal(now.Add(-15*time.Minute)) {
+		t.Errorf("t01: durations should count back from now: %v %v", t01, err)
+	}
+	if t02, err := parseTime("2024-01-02T03:04:05+01:00", now); err != nil || !t02.Equal(now.Add(-time.Hour)) {
+		t.Errorf("t02: invalid RFC3339 time: %v %v", t02, err)
+	}
+	if t03, err := parseTime("2024-01-02", now); err != nil || t03.Day() != 2 || t03.Hour() != 0 {
+		t.Errorf("t03: invalid date: %v %v", t03, err)
+	}
+	if t04, err := parseTime("", now); err != nil || !t04.IsZero() {
+		t.Errorf("t04: empty times should be zero: %v %v", t04, err)
+	}
+	if _, err := parseTime("yesterday", now); err == nil {
+		t.Errorf("t05: invalid times should fail")
+	}
+}
+
+func TestRecordTime(t *testing.T) {
+	x01 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, v := range []interface{}{x01, json.Number("1704164645"), "1704164645000", int64(1704164645000000), json.Number("1704164645000000000"), 1704164645.0} {
+		if r, ok := recordTime(v); !ok || !r.Equal(x01) {
+			t.Errorf("t%02d: invalid time for %v: %v", i+1, v, r)
+		}
+	}
+	if _, ok := recordTime("yesterday"); ok {
+		t.Errorf("t07: invalid times should not be accepted")
+	}
+
+	_, o08, e08 := runKvl("{\"time\":1704164645,\"message\":\"epoch\"}\n{\"message\":\"none\"}\n{\"message\":\"none\"}\n", "-format", "logfmt", "-since", "2024-01-02T00:00:00Z")
+	if o08 != "time=1704164645 message=epoch\n" || strings.Count(e08, "skipping") != 1 {
+		t.Errorf("t08: epochs should be filtered, and dropped records reported once: %q %q", o08, e08)
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	content := "one\ntwo\n" + strings.Repeat("x", 2*tailChunkSize) + "\nfour\n"
+	ioutil.WriteFile(path, []byte(content), 0644)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for i, c := range []struct {
+		lines  int
+		offset int
+	}{
+		{0, len(content)},
+		{1, len(content) - len("four\n")},
+		{2, len("one\ntwo\n")},
+		{3, len("one\n")},
+		{4, 0},
+		{10, 0},
+	} {
+		if offset, err := tailOffset(file, c.lines); err != nil || offset != int64(c.offset) {
+			t.Errorf("t%02d: expected offset %d, got %d %v", i+1, c.offset, offset, err)
+		}
+	}
+
+	follow, err := openFollow(path, 1, time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follow.Close()
+	buffer := make([]byte, 64)
+	if n, _ := follow.Read(buffer); string(buffer[:n]) != "four\n" {
+		t.Errorf("t07: following should start with the last lines: %q", buffer[:n])
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	if err := ioutil.WriteFile(path, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	follow, err := openFollow(path, -1, time.Millisecond, done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follow.Close()
+	buffer := make([]byte, 64)
+	read := func() string {
+		n, _ := follow.Read(buffer)
+		return string(buffer[:n])
+	}
+	if r01 := read(); r01 != "one\n" {
+		t.Errorf("t01: invalid data: %q", r01)
+	}
+
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString("two\n")
+	file.Close()
+	if r02 := read(); r02 != "two\n" {
+		t.Errorf("t02: appended data should be read: %q", r02)
+	}
+
+	os.Rename(path, path+".1")
+	ioutil.WriteFile(path, []byte("three\n"), 0644)
+	if r03 := read(); r03 != "three\n" {
+		t.Errorf("t03: rotated file should be followed: %q", r03)
+	}
+
+	ioutil.WriteFile(path, []byte("4\n"), 0644)
+	if r04 := read(); r04 != "4\n" {
+		t.Errorf("t04: truncated file should be read from the start: %q", r04)
+	}
+
+	close(done)
+	if n, err := follow.Read(buffer); n != 0 || err != io.EOF {
+		t.Errorf("t05: reading should stop when done: %d %v", n, err)
+	}
+}
@@ -0,0 +1,697 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// configTypeKey is the key that selects the constructor of a node.
+	configTypeKey = "type"
+)
+
+// FilterConstructor creates a Filter from a configuration node.
+type FilterConstructor func(config *Config) (Filter, error)
+
+// FormatterConstructor creates a Formatter from a configuration node.
+type FormatterConstructor func(config *Config) (Formatter, error)
+
+// SinkConstructor creates a sink from a configuration node.
+type SinkConstructor func(config *Config) (io.Writer, error)
+
+var (
+	registryMutex  sync.RWMutex
+	filterTypes    = make(map[string]FilterConstructor)
+	formatterTypes = make(map[string]FormatterConstructor)
+	sinkTypes      = make(map[string]SinkConstructor)
+)
+
+// RegisterFilter makes a Filter type available to the configuration
+// loader under a name. Registering a name again replaces the constructor,
+// and a nil constructor removes it.
+func RegisterFilter(name string, constructor FilterConstructor) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if constructor == nil {
+		delete(filterTypes, name)
+	} else {
+		filterTypes[name] = constructor
+	}
+}
+
+// RegisterFormatter makes a Formatter type available to the configuration
+// loader under a name. Registering a name again replaces the constructor,
+// and a nil constructor removes it.
+func RegisterFormatter(name string, constructor FormatterConstructor) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if constructor == nil {
+		delete(formatterTypes, name)
+	} else {
+		formatterTypes[name] = constructor
+	}
+}
+
+// RegisterSink makes a sink type available to the configuration
+// loader under a name. Registering a name again replaces the constructor,
+// and a nil constructor removes it.
+func RegisterSink(name string, constructor SinkConstructor) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if constructor == nil {
+		delete(sinkTypes, name)
+	} else {
+		sinkTypes[name] = constructor
+	}
+}
+
+// ConfigError is a validation error in a configuration document.
+type ConfigError struct {
+	// Path points at the offending node, for example "logger.filters[1].format".
+	Path    string
+	Message string
+}
+
+func (err *ConfigError) Error() string {
+	if err.Path == "" {
+		return err.Message
+	}
+	return err.Path + ": " + err.Message
+}
+
+// LoadConfig reads a JSON configuration document and builds the filter
+// graph it describes.
+//
+// The document describes the root Filter. Each filter, formatter and sink
+// is an object with a "type" key, which selects a registered constructor,
+// and further keys that are passed to the constructor:
+//
+//	{
+//		"type": "multi",
+//		"filters": [{"type": "time", "format": "2006-01-02T15:04:05Z07:00"}],
+//		"logger": {
+//			"type": "logger",
+//			"formatter": {"type": "json", "leading_keys": ["time", "message"]},
+//			"sink": {"type": "stderr"}
+//		}
+//	}
+//
+// The standard types are registered under these names, with settings named
+// like the snake_case version of their struct fields:
+//
+//   - Filters: time, merge, multi, branch, level, dedup, transform, hostinfo,
+//     sequence, flatten, unflatten, limit, recorder, logger
+//   - Formatters: console, pretty, json, logfmt
+//   - Sinks: stdout, stderr, file, discard
+//
+// See RegisterFilter, RegisterFormatter and RegisterSink for adding types.
+//
+// Call Close on the result when the logger is no longer used, so files are
+// closed and records held back by filters are written.
+func LoadConfig(reader io.Reader) (*ConfigLogger, error) {
+	document, err := decodeConfig(reader)
+	if err != nil {
+		return nil, err
+	}
+	return BuildConfig(document)
+}
+
+// decodeConfig reads a JSON configuration document.
+func decodeConfig(reader io.Reader) (interface{}, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, &ConfigError{Message: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	return document, nil
+}
+
+// BuildConfig builds the filter graph described by an already decoded
+// configuration document. This can be used with other document formats,
+// such as YAML: Decode the document with a library of your choice, and
+// pass the result. Both map[string]interface{} and map[interface{}]interface{}
+// are accepted for objects.
+func BuildConfig(document interface{}) (*ConfigLogger, error) {
+	filter, closers, err := buildConfig(document)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigLogger{
+		StdLogger: StdLogger{
+			Logger: filter,
+		},
+		closers: closers,
+	}, nil
+}
+
+// ConfigLogger is a StdLogger built from a configuration document or from
+// environment variables. It owns the filters and sinks that hold resources,
+// like files.
+type ConfigLogger struct {
+	StdLogger
+	closers []io.Closer
+}
+
+// Close closes all filters and sinks of the graph, and returns the first
+// error. Filters are closed before the sinks they write to.
+// The logger must not be used afterwards.
+func (logger *ConfigLogger) Close() error {
+	closers := logger.closers
+	logger.closers = nil
+	return closeAll(closers)
+}
+
+// configBuild collects the state of building one document.
+type configBuild struct {
+	// closers are the filters and sinks that hold resources.
+	closers []io.Closer
+	// commits are destructive actions that are deferred until the whole
+	// document was built successfully.
+	commits []func() error
+}
+
+// buildConfig builds a document and returns the filters and sinks that
+// need to be closed when the graph is no longer used.
+// If building fails, everything opened so far is closed.
+func buildConfig(document interface{}) (Filter, []io.Closer, error) {
+	build := &configBuild{}
+	filter, err := build.filter("", normalizeConfig(document))
+	if err == nil {
+		for _, commit := range build.commits {
+			if err = commit(); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		closeAll(build.closers)
+		return nil, nil, err
+	}
+	return filter, build.closers, nil
+}
+
+// commit defers an action until the whole document was built, so an
+// error in the document does not leave any changes behind.
+func (build *configBuild) commit(action func() error) {
+	build.commits = append(build.commits, action)
+}
+
+// track remembers v if it holds resources.
+// The standard output streams are never closed.
+func (build *configBuild) track(v interface{}) {
+	if closer, ok := v.(io.Closer); ok && v != os.Stdout && v != os.Stderr {
+		build.closers = append(build.closers, closer)
+	}
+}
+
+// closeAll closes resources in reverse order of creation, so filters are
+// closed before the sinks they write to. Returns the first error.
+func closeAll(closers []io.Closer) error {
+	var first error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// normalizeConfig converts map[interface{}]interface{}, as produced by
+// some YAML decoders, into map[string]interface{}.
+func normalizeConfig(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = normalizeConfig(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalizeConfig(e)
+		}
+		return t
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalizeConfig(e)
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// Config is a node in a configuration document.
+// Constructors use its accessors to read their settings. Each accessor
+// returns a ConfigError pointing at the offending key if the value has
+// the wrong type. Keys that are not read by the constructor are reported
+// as errors too.
+//
+// Errors are also remembered by the node, so constructors may ignore the
+// errors of individual accessors and return Err at the end instead.
+type Config struct {
+	path     string
+	values   map[string]interface{}
+	used     map[string]bool
+	children []*Config
+	err      error
+	build    *configBuild
+}
+
+func newConfig(build *configBuild, path string, v interface{}) (*Config, error) {
+	values, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &ConfigError{Path: path, Message: "expected an object"}
+	}
+	return &Config{
+		path:   path,
+		values: values,
+		used:   make(map[string]bool),
+		build:  build,
+	}, nil
+}
+
+// Path returns the location of this node in the document.
+func (config *Config) Path() string {
+	return config.path
+}
+
+// Errorf creates a ConfigError for a key of this node.
+func (config *Config) Errorf(key string, format string, args ...interface{}) error {
+	return &ConfigError{Path: config.keyPath(key), Message: fmt.Sprintf(format, args...)}
+}
+
+// Err returns the first error encountered by an accessor.
+func (config *Config) Err() error {
+	return config.err
+}
+
+// fail remembers the first error and returns it.
+func (config *Config) fail(err error) error {
+	if config.err == nil {
+		config.err = err
+	}
+	return err
+}
+
+// joinConfigPath appends a key to a path in the document.
+func joinConfigPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func (config *Config) keyPath(key string) string {
+	return joinConfigPath(config.path, key)
+}
+
+func (config *Config) get(key string) (interface{}, bool) {
+	config.used[key] = true
+	v, ok := config.values[key]
+	return v, ok && v != nil
+}
+
+// Has checks if a key is present.
+func (config *Config) Has(key string) bool {
+	_, ok := config.get(key)
+	return ok
+}
+
+// String returns a string value, or def if the key is not present.
+func (config *Config) String(key string, def string) (string, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return def, config.fail(config.Errorf(key, "expected a string"))
+	}
+	return s, nil
+}
+
+// Choice returns a string value that must be one of choices, or def if
+// the key is not present.
+func (config *Config) Choice(key string, def string, choices ...string) (string, error) {
+	s, err := config.String(key, def)
+	if err != nil {
+		return def, err
+	}
+	if !containsString(choices, s) {
+		return def, config.fail(config.Errorf(key, "invalid value: %s", s))
+	}
+	return s, nil
+}
+
+// Bool returns a boolean value, or def if the key is not present.
+func (config *Config) Bool(key string, def bool) (bool, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return def, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return def, config.fail(config.Errorf(key, "expected a boolean"))
+	}
+	return b, nil
+}
+
+// Int returns an integer value, or def if the key is not present.
+func (config *Config) Int(key string, def int) (int, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case json.Number:
+		i, err := strconv.Atoi(string(n))
+		if err == nil {
+			return i, nil
+		}
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), nil
+		}
+	}
+	return def, config.fail(config.Errorf(key, "expected an integer"))
+}
+
+// Duration returns a duration, or def if the key is not present.
+// Durations can be given as strings like "1m30s" or as a number of seconds.
+func (config *Config) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return def, nil
+	}
+	switch t := v.(type) {
+	case string:
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return def, config.fail(config.Errorf(key, "invalid duration: %s", t))
+		}
+		return d, nil
+	case json.Number:
+		f, err := t.Float64()
+		if err == nil {
+			return time.Duration(f * float64(time.Second)), nil
+		}
+	case float64:
+		return time.Duration(t * float64(time.Second)), nil
+	case int:
+		return time.Duration(t) * time.Second, nil
+	}
+	return def, config.fail(config.Errorf(key, "expected a duration"))
+}
+
+// Level returns a log level given by name, or def if the key is not present.
+func (config *Config) Level(key string, def Level) (Level, error) {
+	name, err := config.String(key, "")
+	if err != nil || name == "" {
+		return def, err
+	}
+	level, err := ParseLevel(name)
+	if err != nil {
+		return def, config.fail(config.Errorf(key, "invalid level: %s", name))
+	}
+	return level, nil
+}
+
+// Strings returns a list of strings, or nil if the key is not present.
+func (config *Config) Strings(key string) ([]string, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, config.fail(config.Errorf(key, "expected a list"))
+	}
+	strings := make([]string, len(list))
+	for i, e := range list {
+		s, ok := e.(string)
+		if !ok {
+			return nil, config.fail(config.Errorf(fmt.Sprintf("%s[%d]", key, i), "expected a string"))
+		}
+		strings[i] = s
+	}
+	return strings, nil
+}
+
+// Value returns a value as-is, or nil if the key is not present.
+// Numbers are converted to int or float64.
+func (config *Config) Value(key string) interface{} {
+	v, _ := config.get(key)
+	return plainConfigValue(v)
+}
+
+// Dict returns an object as a dictionary, or nil if the key is not present.
+// Numbers are converted to int or float64.
+func (config *Config) Dict(key string) (map[string]interface{}, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	dict, ok := plainConfigValue(v).(map[string]interface{})
+	if !ok {
+		return nil, config.fail(config.Errorf(key, "expected an object"))
+	}
+	return dict, nil
+}
+
+// plainConfigValue converts json.Numbers into int or float64.
+func plainConfigValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := strconv.Atoi(string(t)); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			dict[k] = plainConfigValue(e)
+		}
+		return dict
+	case []interface{}:
+		list := make([]interface{}, len(t))
+		for i, e := range t {
+			list[i] = plainConfigValue(e)
+		}
+		return list
+	default:
+		return v
+	}
+}
+
+// Objects returns the nodes of a list of objects, or nil if the key is
+// not present. Unused keys in these nodes are reported like those of the
+// parent node.
+func (config *Config) Objects(key string) ([]*Config, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, config.fail(config.Errorf(key, "expected a list"))
+	}
+	nodes := make([]*Config, len(list))
+	for i, e := range list {
+		node, err := newConfig(config.build, fmt.Sprintf("%s[%d]", config.keyPath(key), i), e)
+		if err != nil {
+			return nil, config.fail(err)
+		}
+		nodes[i] = node
+		config.children = append(config.children, node)
+	}
+	return nodes, nil
+}
+
+// Filter builds the filter described by a key, or returns nil if the key
+// is not present.
+func (config *Config) Filter(key string) (Filter, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	filter, err := config.build.filter(config.keyPath(key), v)
+	if err != nil {
+		return nil, config.fail(err)
+	}
+	return filter, nil
+}
+
+// Filters builds a list of filters, or returns nil if the key is not present.
+func (config *Config) Filters(key string) ([]Filter, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, config.fail(config.Errorf(key, "expected a list"))
+	}
+	filters := make([]Filter, len(list))
+	for i, e := range list {
+		filter, err := config.build.filter(fmt.Sprintf("%s[%d]", config.keyPath(key), i), e)
+		if err != nil {
+			return nil, config.fail(err)
+		}
+		filters[i] = filter
+	}
+	return filters, nil
+}
+
+// Formatter builds the formatter described by a key, or returns nil if
+// the key is not present.
+func (config *Config) Formatter(key string) (Formatter, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	node, name, err := config.build.node(config.keyPath(key), v)
+	if err != nil {
+		return nil, config.fail(err)
+	}
+	registryMutex.RLock()
+	constructor, ok := formatterTypes[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, config.fail(node.Errorf(configTypeKey, "unknown formatter type: %s", name))
+	}
+	formatter, err := constructor(node)
+	if err = node.finish(err); err != nil {
+		return nil, config.fail(err)
+	}
+	return formatter, nil
+}
+
+// Sink builds the sink described by a key, or returns nil if the key is
+// not present.
+func (config *Config) Sink(key string) (io.Writer, error) {
+	v, ok := config.get(key)
+	if !ok {
+		return nil, nil
+	}
+	node, name, err := config.build.node(config.keyPath(key), v)
+	if err != nil {
+		return nil, config.fail(err)
+	}
+	registryMutex.RLock()
+	constructor, ok := sinkTypes[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, config.fail(node.Errorf(configTypeKey, "unknown sink type: %s", name))
+	}
+	sink, err := constructor(node)
+	config.build.track(sink)
+	if err = node.finish(err); err != nil {
+		return nil, config.fail(err)
+	}
+	return sink, nil
+}
+
+func (build *configBuild) filter(path string, v interface{}) (Filter, error) {
+	node, name, err := build.node(path, v)
+	if err != nil {
+		return nil, err
+	}
+	registryMutex.RLock()
+	constructor, ok := filterTypes[name]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, node.Errorf(configTypeKey, "unknown filter type: %s", name)
+	}
+	filter, err := constructor(node)
+	build.track(filter)
+	if err = node.finish(err); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// node creates a node and reads its type.
+func (build *configBuild) node(path string, v interface{}) (*Config, string, error) {
+	node, err := newConfig(build, path, v)
+	if err != nil {
+		return nil, "", err
+	}
+	name, err := node.String(configTypeKey, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if name == "" {
+		return nil, "", node.Errorf(configTypeKey, "missing type")
+	}
+	return node, name, nil
+}
+
+// finish combines the result of a constructor with errors remembered by
+// the node and unused keys.
+func (config *Config) finish(err error) error {
+	if err != nil {
+		return err
+	}
+	if config.err != nil {
+		return config.err
+	}
+	return config.checkUnused()
+}
+
+// checkUnused reports the first key that was not read by the constructor.
+func (config *Config) checkUnused() error {
+	var unused []string
+	for k := range config.values {
+		if !config.used[k] {
+			unused = append(unused, k)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return config.Errorf(unused[0], "unknown key")
+	}
+	for _, child := range config.children {
+		if err := child.checkUnused(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
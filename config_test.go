@@ -0,0 +1,178 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	RegisterSink("test", func(config *Config) (io.Writer, error) {
+		return buffer, nil
+	})
+	defer RegisterSink("test", nil)
+
+	q01 := `{
+		"type": "multi",
+		"filters": [
+			{"type": "merge", "dict": {"service": "test", "replicas": 3}},
+			{"type": "transform", "rules": [
+				{"op": "rename", "keys": ["message"], "target": "msg"},
+				{"op": "default", "keys": ["level"], "value": "info"}
+			]}
+		],
+		"logger": {
+			"type": "level",
+			"threshold": "info",
+			"logger": {
+				"type": "logger",
+				"formatter": {"type": "json", "leading_keys": ["msg"]},
+				"sink": {"type": "test"}
+			}
+		}
+	}`
+	l01, err := LoadConfig(strings.NewReader(q01))
+	if err != nil {
+		t.Fatalf("t01: cannot load configuration: %v", err)
+	}
+	l01.Printkv("message", "test01", "level", "debug")
+	l01.Printkv("message", "test01")
+	x01 := "{\"msg\":\"test01\",\"level\":\"info\",\"replicas\":3,\"service\":\"test\"}\n"
+	if buffer.String() != x01 {
+		t.Errorf("t01: no match. expected: '%s' got: '%s'", x01, buffer)
+	}
+
+	invalid := []struct {
+		config string
+		path   string
+	}{
+		{`[]`, ""},
+		{`{}`, "type"},
+		{`{"type": "unknown"}`, "type"},
+		{`{"type": "multi", "filter": []}`, "filter"},
+		{`{"type": "multi", "filters": [{"type": "time", "utc": "yes"}]}`, "filters[0].utc"},
+		{`{"type": "multi", "logger": {"type": "logger", "formatter": {"type": "json", "indent": 2}}}`, "logger.formatter.indent"},
+		{`{"type": "logger", "sink": {"type": "file"}}`, "sink.path"},
+		{`{"type": "transform", "rules": [{"op": "rename"}, {"op": "move"}]}`, "rules[1].op"},
+		{`{"type": "transform", "rules": [{"op": "rename", "key": "a"}]}`, "rules[0].key"},
+		{`{"type": "level", "threshold": "loud"}`, "threshold"},
+		{`{"type": "dedup", "window": "often"}`, "window"},
+		{`{"type": "limit", "max_keys": 1.5}`, "max_keys"},
+	}
+	for i, e := range invalid {
+		_, err := LoadConfig(strings.NewReader(e.config))
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || configErr.Path != e.path {
+			t.Errorf("t%02d: expected error at '%s', got: %v", i+2, e.path, err)
+		}
+	}
+}
+
+func TestConfigLoggerClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+	q01 := map[string]interface{}{
+		"type": "dedup",
+		"logger": map[string]interface{}{
+			"type":      "logger",
+			"formatter": map[string]interface{}{"type": "json"},
+			"sink":      map[string]interface{}{"type": "file", "path": path},
+		},
+	}
+	l01, err := BuildConfig(q01)
+	if err != nil {
+		t.Fatalf("t01: cannot build configuration: %v", err)
+	}
+	l01.Printkv("message", "test01")
+	l01.Printkv("message", "test01")
+	if err := l01.Close(); err != nil {
+		t.Errorf("t01: cannot close: %v", err)
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.Count(string(data), "\n") != 2 || !strings.Contains(string(data), DedupRepeatCountKey) {
+		t.Errorf("t01: held back records should be flushed on close: %q", data)
+	}
+	if err := l01.Close(); err != nil {
+		t.Errorf("t02: closing twice should do nothing: %v", err)
+	}
+
+	q03 := map[string]interface{}{
+		"type": "multi",
+		"logger": map[string]interface{}{
+			"type":      "logger",
+			"formatter": map[string]interface{}{"type": "json"},
+			"sink":      map[string]interface{}{"type": "file", "path": path, "truncate": true},
+			"typo":      true,
+		},
+	}
+	if _, err := BuildConfig(q03); err == nil {
+		t.Errorf("t03: invalid configuration should fail")
+	}
+	if data, _ := ioutil.ReadFile(path); len(data) == 0 {
+		t.Errorf("t03: the file should not be truncated when building fails")
+	}
+
+	delete(q03["logger"].(map[string]interface{}), "typo")
+	l04, err := BuildConfig(q03)
+	if err != nil {
+		t.Fatalf("t04: cannot build configuration: %v", err)
+	}
+	l04.Printkv("message", "test04")
+	l04.Close()
+	if data, _ := ioutil.ReadFile(path); strings.Count(string(data), "\n") != 1 {
+		t.Errorf("t04: the file should be truncated: %q", data)
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	// as produced by YAML decoders
+	q01 := map[interface{}]interface{}{
+		"type": "multi",
+		"filters": []interface{}{
+			map[interface{}]interface{}{"type": "sequence", "session": "s"},
+		},
+	}
+	l01, err := BuildConfig(q01)
+	if err != nil {
+		t.Fatalf("t01: cannot build configuration: %v", err)
+	}
+	c01 := map[string]interface{}{}
+	l01.Printd(c01)
+	if c01[SessionKey] != "s" {
+		t.Errorf("t01: filter was not built: %v", c01)
+	}
+}
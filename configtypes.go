@@ -0,0 +1,332 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// register the standard types with the configuration loader
+func init() {
+	RegisterFilter("time", newTimeFilterConfig)
+	RegisterFilter("merge", newMergeFilterConfig)
+	RegisterFilter("multi", newMultiFilterConfig)
+	RegisterFilter("branch", newBranchFilterConfig)
+	RegisterFilter("level", newLevelFilterConfig)
+	RegisterFilter("dedup", newDedupFilterConfig)
+	RegisterFilter("transform", newTransformFilterConfig)
+	RegisterFilter("hostinfo", newHostInfoFilterConfig)
+	RegisterFilter("sequence", newSequenceFilterConfig)
+	RegisterFilter("flatten", newFlattenFilterConfig)
+	RegisterFilter("unflatten", newUnflattenFilterConfig)
+	RegisterFilter("limit", newLimitFilterConfig)
+	RegisterFilter("recorder", newFlightRecorderConfig)
+	RegisterFilter("logger", newLoggerConfig)
+	RegisterFormatter("console", newConsoleFormatterConfig)
+	RegisterFormatter("pretty", newPrettyFormatterConfig)
+	RegisterFormatter("json", newJsonFormatterConfig)
+	RegisterFormatter("logfmt", newLogfmtFormatterConfig)
+	RegisterSink("stdout", func(config *Config) (io.Writer, error) {
+		return os.Stdout, nil
+	})
+	RegisterSink("stderr", func(config *Config) (io.Writer, error) {
+		return os.Stderr, nil
+	})
+	RegisterSink("discard", func(config *Config) (io.Writer, error) {
+		return ioutil.Discard, nil
+	})
+	RegisterSink("file", newFileSinkConfig)
+}
+
+// Settings: format, utc, truncate, epoch (s, ms, ns)
+func newTimeFilterConfig(config *Config) (Filter, error) {
+	filter := &AddTimeFilter{}
+	filter.TimeFormat, _ = config.String("format", "")
+	filter.UTC, _ = config.Bool("utc", false)
+	filter.Truncate, _ = config.Duration("truncate", 0)
+	switch epoch, _ := config.Choice("epoch", "", "", "s", "ms", "ns"); epoch {
+	case "s":
+		filter.Epoch = EpochSeconds
+	case "ms":
+		filter.Epoch = EpochMillis
+	case "ns":
+		filter.Epoch = EpochNanos
+	}
+	return filter, config.Err()
+}
+
+// Settings: dict
+func newMergeFilterConfig(config *Config) (Filter, error) {
+	dict, err := config.Dict("dict")
+	return &MergeFilter{
+		Dict: dict,
+	}, err
+}
+
+// Settings: filters, logger
+func newMultiFilterConfig(config *Config) (Filter, error) {
+	filter := &MultiFilter{}
+	filter.Filters, _ = config.Filters("filters")
+	filter.Logger, _ = config.Filter("logger")
+	return filter, config.Err()
+}
+
+// Settings: loggers
+func newBranchFilterConfig(config *Config) (Filter, error) {
+	loggers, err := config.Filters("loggers")
+	return &BranchFilter{
+		Loggers: loggers,
+	}, err
+}
+
+// Settings: threshold, logger
+func newLevelFilterConfig(config *Config) (Filter, error) {
+	filter := &LevelFilter{}
+	filter.Threshold, _ = config.Level("threshold", LevelInfo)
+	filter.Logger, _ = config.Filter("logger")
+	return filter, config.Err()
+}
+
+// Settings: keys, window, logger
+func newDedupFilterConfig(config *Config) (Filter, error) {
+	filter := &DedupFilter{}
+	filter.Keys, _ = config.Strings("keys")
+	filter.Window, _ = config.Duration("window", 0)
+	filter.Logger, _ = config.Filter("logger")
+	return filter, config.Err()
+}
+
+// Settings: rules, a list of objects with op (rename, copy, delete, keep,
+// nest, default), keys, target and value
+func newTransformFilterConfig(config *Config) (Filter, error) {
+	filter := &TransformFilter{}
+	rules, _ := config.Objects("rules")
+	for _, node := range rules {
+		rule := TransformRule{}
+		switch op, _ := node.Choice("op", "", "rename", "copy", "delete", "keep", "nest", "default"); op {
+		case "rename":
+			rule.Op = TransformRename
+		case "copy":
+			rule.Op = TransformCopy
+		case "delete":
+			rule.Op = TransformDelete
+		case "keep":
+			rule.Op = TransformKeep
+		case "nest":
+			rule.Op = TransformNest
+		case "default":
+			rule.Op = TransformDefault
+		}
+		rule.Keys, _ = node.Strings("keys")
+		rule.Target, _ = node.String("target", "")
+		rule.Value = node.Value("value")
+		if err := node.Err(); err != nil {
+			return nil, err
+		}
+		filter.Rules = append(filter.Rules, rule)
+	}
+	return filter, config.Err()
+}
+
+// Settings: hostname, pid, executable, go_version, module_version, vcs_revision, container_id
+func newHostInfoFilterConfig(config *Config) (Filter, error) {
+	filter := &HostInfoFilter{}
+	filter.Hostname, _ = config.Bool("hostname", false)
+	filter.Pid, _ = config.Bool("pid", false)
+	filter.Executable, _ = config.Bool("executable", false)
+	filter.GoVersion, _ = config.Bool("go_version", false)
+	filter.ModuleVersion, _ = config.Bool("module_version", false)
+	filter.VcsRevision, _ = config.Bool("vcs_revision", false)
+	filter.ContainerId, _ = config.Bool("container_id", false)
+	return filter, config.Err()
+}
+
+// Settings: session, sequence_key, session_key
+func newSequenceFilterConfig(config *Config) (Filter, error) {
+	filter := &SequenceFilter{}
+	filter.Session, _ = config.String("session", "")
+	filter.SequenceKey, _ = config.String("sequence_key", "")
+	filter.SessionKey, _ = config.String("session_key", "")
+	return filter, config.Err()
+}
+
+// configFlattenStyle reads the style setting of the flatten filters.
+func configFlattenStyle(config *Config) FlattenStyle {
+	style, _ := config.Choice("style", "dotted", "dotted", "bracketed")
+	if style == "bracketed" {
+		return FlattenBracketed
+	}
+	return FlattenDotted
+}
+
+// Settings: style (dotted, bracketed), separator, max_depth
+func newFlattenFilterConfig(config *Config) (Filter, error) {
+	filter := &FlattenFilter{}
+	filter.Style = configFlattenStyle(config)
+	filter.Separator, _ = config.String("separator", "")
+	filter.MaxDepth, _ = config.Int("max_depth", 0)
+	return filter, config.Err()
+}
+
+// Settings: style (dotted, bracketed), separator, max_depth
+func newUnflattenFilterConfig(config *Config) (Filter, error) {
+	filter := &UnflattenFilter{}
+	filter.Style = configFlattenStyle(config)
+	filter.Separator, _ = config.String("separator", "")
+	filter.MaxDepth, _ = config.Int("max_depth", 0)
+	return filter, config.Err()
+}
+
+// Settings: max_string_length, max_keys, max_depth, max_size
+func newLimitFilterConfig(config *Config) (Filter, error) {
+	filter := &LimitFilter{}
+	filter.MaxStringLength, _ = config.Int("max_string_length", 0)
+	filter.MaxKeys, _ = config.Int("max_keys", 0)
+	filter.MaxDepth, _ = config.Int("max_depth", 0)
+	filter.MaxSize, _ = config.Int("max_size", 0)
+	return filter, config.Err()
+}
+
+// Settings: max_records, max_bytes, dump_on_error, logger, dump
+func newFlightRecorderConfig(config *Config) (Filter, error) {
+	recorder := &FlightRecorder{}
+	recorder.MaxRecords, _ = config.Int("max_records", 0)
+	recorder.MaxBytes, _ = config.Int("max_bytes", 0)
+	recorder.DumpOnError, _ = config.Bool("dump_on_error", false)
+	recorder.Logger, _ = config.Filter("logger")
+	recorder.Dump, _ = config.Filter("dump")
+	return recorder, config.Err()
+}
+
+// Settings: formatter, sink
+func newLoggerConfig(config *Config) (Filter, error) {
+	logger := &Logger{}
+	logger.Formatter, _ = config.Formatter("formatter")
+	logger.Sink, _ = config.Sink("sink")
+	return logger, config.Err()
+}
+
+// configConsoleFormatter reads the settings shared by the console and
+// pretty formatters.
+func configConsoleFormatter(config *Config, formatter *ConsoleFormatter) {
+	formatter.PrintTime, _ = config.Bool("print_time", false)
+	formatter.PrintKeys, _ = config.Bool("print_keys", false)
+	formatter.SortKeys, _ = config.Bool("sort_keys", false)
+	switch escape, _ := config.Choice("escape", "control", "control", "quote", "multiline", "none"); escape {
+	case "quote":
+		formatter.Escape = EscapeQuote
+	case "multiline":
+		formatter.Escape = EscapeMultiline
+	case "none":
+		formatter.Escape = EscapeNone
+	}
+}
+
+// Settings: print_time, print_keys, sort_keys, escape (control, quote,
+// multiline, none), columns, message_width, max_column_width, max_columns
+func newConsoleFormatterConfig(config *Config) (Formatter, error) {
+	formatter := &ConsoleFormatter{}
+	configConsoleFormatter(config, formatter)
+	formatter.Columns, _ = config.Bool("columns", false)
+	formatter.MessageWidth, _ = config.Int("message_width", 0)
+	formatter.MaxColumnWidth, _ = config.Int("max_column_width", 0)
+	formatter.MaxColumns, _ = config.Int("max_columns", 0)
+	return formatter, config.Err()
+}
+
+// Settings: print_time, print_keys, sort_keys, escape, no_color
+func newPrettyFormatterConfig(config *Config) (Formatter, error) {
+	formatter := &PrettyFormatter{}
+	configConsoleFormatter(config, &formatter.ConsoleFormatter)
+	formatter.NoColor, _ = config.Bool("no_color", false)
+	return formatter, config.Err()
+}
+
+// Settings: key_order (sorted, none, insertion), leading_keys, time_format,
+// disable_html_escape, float_format, float_precision, indent
+func newJsonFormatterConfig(config *Config) (Formatter, error) {
+	formatter := &JsonFormatter{}
+	switch order, _ := config.Choice("key_order", "sorted", "sorted", "none", "insertion"); order {
+	case "none":
+		formatter.KeyOrder = KeyOrderNone
+	case "insertion":
+		formatter.KeyOrder = KeyOrderInsertion
+	}
+	formatter.LeadingKeys, _ = config.Strings("leading_keys")
+	formatter.TimeFormat, _ = config.String("time_format", "")
+	formatter.DisableHTMLEscape, _ = config.Bool("disable_html_escape", false)
+	if format, _ := config.Choice("float_format", "", "", "f", "e", "g"); format != "" {
+		formatter.FloatFormat = format[0]
+	}
+	formatter.FloatPrecision, _ = config.Int("float_precision", -1)
+	formatter.Indent, _ = config.String("indent", "")
+	return formatter, config.Err()
+}
+
+// Settings: leading_keys, time_format
+func newLogfmtFormatterConfig(config *Config) (Formatter, error) {
+	formatter := &LogfmtFormatter{}
+	formatter.LeadingKeys, _ = config.Strings("leading_keys")
+	formatter.TimeFormat, _ = config.String("time_format", "")
+	return formatter, config.Err()
+}
+
+// Settings: path, truncate
+// The file is opened for appending. If truncate is set, it is truncated
+// once the whole document was built successfully.
+func newFileSinkConfig(config *Config) (io.Writer, error) {
+	path, _ := config.String("path", "")
+	truncate, _ := config.Bool("truncate", false)
+	if err := config.Err(); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, config.Errorf("path", "missing path")
+	}
+	file, err := openLogFile(path, false)
+	if err != nil {
+		return nil, config.Errorf("path", "%v", err)
+	}
+	if truncate {
+		config.build.commit(func() error {
+			if err := file.Truncate(0); err != nil {
+				return config.Errorf("truncate", "%v", err)
+			}
+			return nil
+		})
+	}
+	return file, nil
+}
+
+// openLogFile opens a log file for writing.
+func openLogFile(path string, truncate bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	return os.OpenFile(path, flags, 0644)
+}
@@ -27,12 +27,60 @@ package kvl
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	ConsoleTimeFormat  = "[2006-01-02 15:04:05]"
 	invalidMessageType = "(message not printable)"
+	// consoleContinuation is the indentation of continuation lines
+	// in EscapeMultiline mode.
+	consoleContinuation = "    "
+	// DefaultMaxColumnWidth is the default for ConsoleFormatter.MaxColumnWidth.
+	DefaultMaxColumnWidth = 40
+	// DefaultMaxColumns is the default for ConsoleFormatter.MaxColumns.
+	DefaultMaxColumns = 8
+	// maxColumnCandidates limits the number of keys that are remembered
+	// in column mode until they recur.
+	maxColumnCandidates = 256
+)
+
+// ConsoleEscape determines how ConsoleFormatter deals with characters that
+// could forge log lines or manipulate the terminal.
+type ConsoleEscape int
+
+const (
+	// EscapeControl replaces control characters, including newlines and
+	// terminal escape sequences, with Go-style escape sequences.
+	// This is the default.
+	EscapeControl ConsoleEscape = iota
+	// EscapeQuote escapes control characters like EscapeControl, and
+	// additionally quotes values that contain spaces, pipe characters or
+	// quotes, keys that also contain colons, and messages that contain
+	// pipe characters or start with a quote, so that key-value pairs
+	// cannot be forged.
+	EscapeQuote
+	// EscapeMultiline escapes control characters like EscapeControl,
+	// except for newlines: Multi-line messages and values are printed on
+	// continuation lines, indented with four spaces.
+	EscapeMultiline
+	// EscapeNone prints everything verbatim.
+	// Only use this for trusted input.
+	EscapeNone
+)
+
+// consolePart selects the quoting rules of EscapeQuote.
+type consolePart int
+
+const (
+	partMessage consolePart = iota
+	partKey
+	partValue
 )
 
 var (
@@ -53,6 +101,9 @@ var (
 // If the PrintKeys flag is true and additional keys besides StdMessageKey and
 // StdTimeKey are present, they will be logged after the message, separated
 // by pipe characters: |
+//
+// Messages, keys and values are escaped according to Escape, which
+// protects against log injection by default.
 type ConsoleFormatter struct {
 	// PrintTime determines if each log will be prepended with date and time.
 	PrintTime bool
@@ -60,21 +111,141 @@ type ConsoleFormatter struct {
 	PrintKeys bool
 	// SortKeys determines if keys should be sorted alphabetically.
 	SortKeys bool
+	// Escape determines how special characters are handled.
+	Escape ConsoleEscape
+	// Columns enables column mode: Messages are padded to MessageWidth,
+	// and keys that recur are turned into columns, which are printed in
+	// the same order and padded to the widest value seen so far, so they
+	// line up like a table. Other keys follow the columns.
+	// Columns are ordered by promotion; SortKeys only affects the order
+	// of columns that are promoted in the same record, and of other keys.
+	Columns bool
+	// MessageWidth is the width messages are padded to in column mode.
+	MessageWidth int
+	// MaxColumnWidth limits the width learned for a column in column mode.
+	// Longer values are not truncated, but do not widen the column.
+	// Defaults to DefaultMaxColumnWidth if unset.
+	MaxColumnWidth int
+	// MaxColumns limits the number of columns in column mode. When a key
+	// recurs and all columns are taken, the least recently used column
+	// is replaced. Defaults to DefaultMaxColumns if unset.
+	MaxColumns int
+
+	mutex      sync.Mutex
+	columns    []string
+	widths     map[string]int
+	used       map[string]uint64
+	candidates map[string]bool
+	records    uint64
 }
 
-func printKey(line *string, k string, v interface{}) {
+func (formatter *ConsoleFormatter) printKey(line *string, k string, v interface{}) {
 	// skip if this is one of the keys with special meaning
 	if _, ok := skipKeys[k]; !ok {
-		*line += fmt.Sprintf(" | %s: %v", k, v)
+		*line += fmt.Sprintf(" | %s: %s", formatter.escape(k, partKey), formatter.escape(consoleValue(v, 0), partValue))
+	}
+}
+
+// escape makes s safe for output according to the Escape mode.
+// part selects the quoting rules of EscapeQuote.
+func (formatter *ConsoleFormatter) escape(s string, part consolePart) string {
+	switch formatter.Escape {
+	case EscapeNone:
+		return s
+	case EscapeQuote:
+		if needsConsoleQuote(s, part) {
+			return strconv.Quote(s)
+		}
+		return escapeControl(s, false)
+	case EscapeMultiline:
+		return strings.Replace(escapeControl(s, true), "\n", "\n"+consoleContinuation, -1)
+	default:
+		return escapeControl(s, false)
+	}
+}
+
+// needsConsoleQuote checks if s could be mistaken for a separator or
+// a key-value pair in EscapeQuote mode.
+func needsConsoleQuote(s string, part consolePart) bool {
+	switch part {
+	case partKey:
+		return s == "" || strings.ContainsAny(s, " |\":")
+	case partValue:
+		return s == "" || strings.ContainsAny(s, " |\"")
+	default:
+		return strings.Contains(s, "|") || strings.HasPrefix(s, "\"")
+	}
+}
+
+// escapeControl replaces control characters with escape sequences.
+// Newlines are kept if keepNewlines is true.
+func escapeControl(s string, keepNewlines bool) string {
+	clean := true
+	for _, r := range s {
+		if r == utf8.RuneError || isControl(r) && !(keepNewlines && r == '\n') {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return s
+	}
+	var escaped strings.Builder
+	for i, r := range s {
+		switch {
+		case r == utf8.RuneError:
+			// keep invalid bytes visible, but harmless
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				escaped.WriteString(fmt.Sprintf("\\x%02x", s[i]))
+			} else {
+				escaped.WriteRune(r)
+			}
+		case keepNewlines && r == '\n':
+			escaped.WriteRune(r)
+		case isControl(r):
+			quoted := strconv.QuoteRune(r)
+			escaped.WriteString(quoted[1 : len(quoted)-1])
+		default:
+			escaped.WriteRune(r)
+		}
+	}
+	return escaped.String()
+}
+
+// isControl checks for characters that can manipulate a terminal or break
+// up log lines.
+func isControl(r rune) bool {
+	return unicode.IsControl(r) || r == '\u2028' || r == '\u2029'
+}
+
+// consoleValue formats a value like %v does, except for LogMarshalers
+// and types with a registered marshaler, which are formatted as
+// {key: value, ...}
+func consoleValue(v interface{}, depth int) string {
+	v = Resolve(v)
+	if depth > maxJsonDepth || !hasMarshaler(v) {
+		return fmt.Sprint(v)
+	}
+	var fields []Field
+	marshalLog(v, func(field Field) {
+		fields = append(fields, field)
+	})
+	object := "{"
+	for i, field := range fields {
+		if i > 0 {
+			object += ", "
+		}
+		object += field.Key + ": " + consoleValue(field.Value(), depth+1)
 	}
+	return object + "}"
 }
 
 func (formatter *ConsoleFormatter) Formatd(dict map[string]interface{}, sink io.Writer) {
 	var line string
 	if formatter.PrintTime {
-		switch t := dict[StdTimeKey].(type) {
+		switch t := Resolve(dict[StdTimeKey]).(type) {
 		case string:
-			line += t
+			line += formatter.escape(t, partMessage)
 			line += " "
 		case time.Time:
 			line += t.Format(ConsoleTimeFormat)
@@ -84,23 +255,147 @@ func (formatter *ConsoleFormatter) Formatd(dict map[string]interface{}, sink io.
 			//line += time.Now().Format(ConsoleTimeFormat)
 		}
 	}
-	switch m := dict[StdMessageKey].(type) {
-	case string:
-		line += m
-	default:
-		line += invalidMessageType
+	message, ok := Resolve(dict[StdMessageKey]).(string)
+	if ok {
+		message = formatter.escape(message, partMessage)
+	} else {
+		message = invalidMessageType
+	}
+	if formatter.Columns {
+		var keys string
+		if formatter.PrintKeys {
+			keys = formatter.printColumns(dict)
+		}
+		if keys != "" {
+			message = padRight(message, formatter.MessageWidth)
+		}
+		line += message + keys + "\n"
+		sink.Write([]byte(line))
+		return
 	}
+	line += message
 	if formatter.PrintKeys {
 		if formatter.SortKeys {
 			for _, k := range OrderedStringKeys(dict) {
-				printKey(&line, k, dict[k])
+				formatter.printKey(&line, k, dict[k])
 			}
 		} else {
 			for k, v := range dict {
-				printKey(&line, k, v)
+				formatter.printKey(&line, k, v)
 			}
 		}
 	}
 	line += "\n"
 	sink.Write([]byte(line))
 }
+
+// printColumns formats the keys of a record in column mode and updates
+// the learned columns.
+func (formatter *ConsoleFormatter) printColumns(dict map[string]interface{}) string {
+	values := make(map[string]string, len(dict))
+	var recurring []string
+	formatter.mutex.Lock()
+	defer formatter.mutex.Unlock()
+	if formatter.widths == nil {
+		formatter.widths = make(map[string]int)
+		formatter.used = make(map[string]uint64)
+		formatter.candidates = make(map[string]bool)
+	}
+	formatter.records++
+	for k, v := range dict {
+		if skipKeys[k] {
+			continue
+		}
+		values[k] = formatter.escape(consoleValue(v, 0), partValue)
+		if _, ok := formatter.widths[k]; ok {
+			formatter.used[k] = formatter.records
+		} else if formatter.candidates[k] {
+			delete(formatter.candidates, k)
+			recurring = append(recurring, k)
+		} else {
+			if len(formatter.candidates) >= maxColumnCandidates {
+				// forget keys that did not recur in a long time
+				formatter.candidates = make(map[string]bool)
+			}
+			formatter.candidates[k] = true
+		}
+	}
+	if formatter.SortKeys {
+		sortStrings(recurring)
+	}
+	for _, k := range recurring {
+		formatter.promote(k)
+	}
+	maxWidth := formatter.MaxColumnWidth
+	if maxWidth <= 0 {
+		maxWidth = DefaultMaxColumnWidth
+	}
+	var line, padding string
+	for _, k := range formatter.columns {
+		key := formatter.escape(k, partKey)
+		value, ok := values[k]
+		width := formatter.widths[k]
+		if !ok {
+			// keep the following columns aligned
+			padding += strings.Repeat(" ", len(" | ")+utf8.RuneCountInString(key)+len(": ")+width)
+			continue
+		}
+		if n := utf8.RuneCountInString(value); n > width && n <= maxWidth {
+			width = n
+			formatter.widths[k] = width
+		}
+		line += padding + " | " + key + ": " + padRight(value, width)
+		padding = ""
+	}
+	var others []string
+	for k := range values {
+		if _, ok := formatter.widths[k]; !ok {
+			others = append(others, k)
+		}
+	}
+	if formatter.SortKeys {
+		sortStrings(others)
+	}
+	if len(others) > 0 {
+		line += padding
+	}
+	for _, k := range others {
+		line += " | " + formatter.escape(k, partKey) + ": " + values[k]
+	}
+	return strings.TrimRight(line, " ")
+}
+
+// promote turns a recurring key into a column. If all columns are taken,
+// the least recently used one is replaced, unless all of them are used by
+// the current record.
+func (formatter *ConsoleFormatter) promote(k string) {
+	maxColumns := formatter.MaxColumns
+	if maxColumns <= 0 {
+		maxColumns = DefaultMaxColumns
+	}
+	if len(formatter.columns) >= maxColumns {
+		oldest := -1
+		for i, column := range formatter.columns {
+			if formatter.used[column] < formatter.records && (oldest < 0 || formatter.used[column] < formatter.used[formatter.columns[oldest]]) {
+				oldest = i
+			}
+		}
+		if oldest < 0 {
+			return
+		}
+		delete(formatter.widths, formatter.columns[oldest])
+		delete(formatter.used, formatter.columns[oldest])
+		formatter.columns = append(formatter.columns[:oldest], formatter.columns[oldest+1:]...)
+	}
+	formatter.columns = append(formatter.columns, k)
+	formatter.widths[k] = 0
+	formatter.used[k] = formatter.records
+}
+
+// padRight pads s with spaces to width runes.
+func padRight(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
@@ -27,6 +27,7 @@ package kvl
 import (
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"testing"
 	"time"
 )
@@ -140,3 +141,134 @@ func TestConsoleFormatter(t *testing.T) {
 		t.Error("t06: log result and output are not equal")
 	}
 }
+
+func TestConsoleFormatterEscape(t *testing.T) {
+	q01 := map[string]interface{}{
+		"message": "line 1\nfake line\x1b[31m",
+		"key\n":   "value\r\n\u0085\xff",
+	}
+	c01 := func() *ConsoleFormatter {
+		return &ConsoleFormatter{
+			PrintKeys: true,
+		}
+	}
+	x01 := []byte("line 1\\nfake line\\x1b[31m | key\\n: value\\r\\n\\u0085\\xff\n")
+	consoleTest(t, "t01", c01, q01, x01)
+
+	q02 := map[string]interface{}{
+		"message": "test02 02test",
+		"a":       "forged | b: 1",
+		"b":       "plain",
+		"c":       "",
+		"d":       "tab\t",
+	}
+	c02 := func() *ConsoleFormatter {
+		return &ConsoleFormatter{
+			PrintKeys: true,
+			SortKeys:  true,
+			Escape:    EscapeQuote,
+		}
+	}
+	x02 := []byte("test02 02test | a: \"forged | b: 1\" | b: plain | c: \"\" | d: tab\\t\n")
+	consoleTest(t, "t02", c02, q02, x02)
+
+	q03 := map[string]interface{}{
+		"message": "test03\nsecond\x1b",
+		"stack":   "main()\nrun()",
+	}
+	c03 := func() *ConsoleFormatter {
+		return &ConsoleFormatter{
+			PrintKeys: true,
+			Escape:    EscapeMultiline,
+		}
+	}
+	x03 := []byte("test03\n    second\\x1b | stack: main()\n    run()\n")
+	consoleTest(t, "t03", c03, q03, x03)
+
+	q04 := map[string]interface{}{
+		"message": "test04\n",
+	}
+	c04 := func() *ConsoleFormatter {
+		return &ConsoleFormatter{
+			Escape: EscapeNone,
+		}
+	}
+	x04 := []byte("test04\n\n")
+	consoleTest(t, "t04", c04, q04, x04)
+
+	q05 := map[string]interface{}{
+		"message":          "test05 | admin: true",
+		"user | admin":     "true",
+		"role: x":          "user",
+		"\"quoted\" plain": 1,
+	}
+	x05 := []byte("\"test05 | admin: true\" | \"\\\"quoted\\\" plain\": 1 | \"role: x\": user | \"user | admin\": true\n")
+	consoleTest(t, "t05", c02, q05, x05)
+
+	q06 := map[string]interface{}{
+		"message": "\"test06\" done",
+	}
+	x06 := []byte("\"\\\"test06\\\" done\"\n")
+	consoleTest(t, "t06", c02, q06, x06)
+}
+
+func TestConsoleFormatterColumns(t *testing.T) {
+	b01 := &bytes.Buffer{}
+	f01 := &ConsoleFormatter{
+		PrintKeys:    true,
+		SortKeys:     true,
+		Columns:      true,
+		MessageWidth: 10,
+	}
+	f01.Formatd(map[string]interface{}{"message": "short", "b": 1, "a": "xy"}, b01)
+	f01.Formatd(map[string]interface{}{"message": "much longer message", "a": "x", "b": 1000}, b01)
+	f01.Formatd(map[string]interface{}{"message": "new", "c": true, "b": 7}, b01)
+	f01.Formatd(map[string]interface{}{"message": "none"}, b01)
+	f01.Formatd(map[string]interface{}{"message": "short", "b": 1, "a": "xy"}, b01)
+	x01 := "short      | a: xy | b: 1\n" +
+		"much longer message | a: x | b: 1000\n" +
+		"new               | b: 7    | c: true\n" +
+		"none\n" +
+		"short      | a: xy | b: 1\n"
+	if b01.String() != x01 {
+		t.Errorf("t01: no match. expected:\n%s\ngot:\n%s", x01, b01)
+	}
+
+	b02 := &bytes.Buffer{}
+	f02 := &ConsoleFormatter{
+		PrintKeys:      true,
+		SortKeys:       true,
+		Columns:        true,
+		MaxColumnWidth: 3,
+	}
+	f02.Formatd(map[string]interface{}{"message": "a", "k": "too long", "l": 1}, b02)
+	f02.Formatd(map[string]interface{}{"message": "b", "l": 2, "k": "ok"}, b02)
+	if b02.String() != "a | k: too long | l: 1\nb | k: ok | l: 2\n" {
+		t.Errorf("t02: invalid output:\n%s", b02)
+	}
+
+	b03 := &bytes.Buffer{}
+	f03 := &ConsoleFormatter{
+		PrintKeys:  true,
+		SortKeys:   true,
+		Columns:    true,
+		MaxColumns: 2,
+	}
+	f03.Formatd(map[string]interface{}{"message": "r1", "a": 1, "b": 2}, b03)
+	f03.Formatd(map[string]interface{}{"message": "r2", "a": 1, "b": 2}, b03)
+	f03.Formatd(map[string]interface{}{"message": "r3", "c": 3}, b03)
+	f03.Formatd(map[string]interface{}{"message": "r4", "a": 1, "c": 3}, b03)
+	x03 := "r1 | a: 1 | b: 2\n" +
+		"r2 | a: 1 | b: 2\n" +
+		"r3               | c: 3\n" +
+		"r4 | a: 1 | c: 3\n"
+	if b03.String() != x03 {
+		t.Errorf("t03: the least recently used column should be replaced. expected:\n%s\ngot:\n%s", x03, b03)
+	}
+	for i := 0; i < 2*maxColumnCandidates; i++ {
+		f03.Formatd(map[string]interface{}{"message": "unique", fmt.Sprintf("id%d", i): i}, ioutil.Discard)
+	}
+	if len(f03.columns) != 2 || len(f03.widths) != 2 || len(f03.candidates) > maxColumnCandidates {
+		t.Errorf("t04: column state should be bounded: %v %d", f03.columns, len(f03.candidates))
+	}
+}
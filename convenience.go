@@ -25,10 +25,53 @@
 package kvl
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"time"
 )
 
+const (
+	// EnvFormat selects the output format: console, json or logfmt.
+	EnvFormat = "KVL_FORMAT"
+	// EnvLevel is the minimum level of logged records.
+	// It can be overridden for a named logger with KVL_LEVEL_<name>.
+	EnvLevel = "KVL_LEVEL"
+	// EnvOutput is stdout, stderr or the path of a log file.
+	EnvOutput = "KVL_OUTPUT"
+	// EnvTimeFormat is a time layout or the name of a predefined layout.
+	EnvTimeFormat = "KVL_TIME_FORMAT"
+)
+
+var (
+	// envTimeFormats maps lowercase names to predefined time layouts.
+	envTimeFormats = map[string]string{
+		"ansic":       time.ANSIC,
+		"unixdate":    time.UnixDate,
+		"rubydate":    time.RubyDate,
+		"rfc822":      time.RFC822,
+		"rfc822z":     time.RFC822Z,
+		"rfc850":      time.RFC850,
+		"rfc1123":     time.RFC1123,
+		"rfc1123z":    time.RFC1123Z,
+		"rfc3339":     time.RFC3339,
+		"rfc3339nano": time.RFC3339Nano,
+		"kitchen":     time.Kitchen,
+		"stamp":       time.Stamp,
+		"stampmilli":  time.StampMilli,
+		"stampmicro":  time.StampMicro,
+		"stampnano":   time.StampNano,
+		"datetime":    "2006-01-02 15:04:05",
+	}
+	// envEpochs maps names to epoch units.
+	envEpochs = map[string]EpochUnit{
+		"unix":   EpochSeconds,
+		"unixms": EpochMillis,
+		"unixns": EpochNanos,
+	}
+)
+
 // NewStdLog creates a simple StdOut logger suitable for human consumption.
 // Key-Value pairs are separated by a pipe character: |
 // Each log line is prepended with the current date and time.
@@ -51,7 +94,8 @@ func NewStdLog() *StdLogger {
 }
 
 // NewJsonLog creates a JSON logger that places each message into the
-// StdMessageKey key and adds StdMessageKey with the current time in RFC3339 format.
+// StdMessageKey key and adds StdTimeKey with the current time in RFC3339 format.
+// The keys in StdLeadingKeys are written first.
 func NewJsonLog() *StdLogger {
 	return &StdLogger{
 		Logger: &MultiFilter{
@@ -61,9 +105,167 @@ func NewJsonLog() *StdLogger {
 				},
 			},
 			Logger: &Logger{
-				Formatter: &JsonFormatter{},
-				Sink:      os.Stdout,
+				Formatter: &JsonFormatter{
+					JsonEncoder: JsonEncoder{
+						LeadingKeys: StdLeadingKeys,
+					},
+				},
+				Sink: os.Stdout,
+			},
+		},
+	}
+}
+
+// NewFromEnv creates a logger that is configured through environment
+// variables, so operators can change format and verbosity without
+// code changes:
+//
+//	KVL_FORMAT        console (default), json or logfmt
+//	KVL_LEVEL         minimum level, for example debug; logs everything if unset
+//	KVL_LEVEL_<name>  minimum level for the logger with this name
+//	KVL_OUTPUT        stdout (default), stderr or a file path to append to
+//	KVL_TIME_FORMAT   a time layout, a layout name like rfc3339 or kitchen,
+//	                  or unix, unixms, unixns for epoch timestamps in json
+//	                  and logfmt format
+//
+// For KVL_LEVEL_<name>, characters in the name that are not allowed in
+// variable names may be replaced by underscores, and the name may be
+// in upper case: KVL_LEVEL_db.pool, KVL_LEVEL_db_pool and KVL_LEVEL_DB_POOL
+// all apply to the name "db.pool".
+//
+// Without any environment variables, the result is equivalent to NewStdLog.
+// Invalid settings are reported in the returned error, but a working logger
+// is always returned, with defaults in place of the invalid settings.
+// Close the logger when it is no longer used, to close the file that was
+// opened for KVL_OUTPUT.
+func NewFromEnv(name string) (*ConfigLogger, error) {
+	return newFromEnv(name, os.LookupEnv)
+}
+
+// newFromEnv implements NewFromEnv with a replaceable environment.
+func newFromEnv(name string, lookup func(string) (string, bool)) (*ConfigLogger, error) {
+	var first error
+	fail := func(key string, value string, message string) {
+		if first == nil {
+			first = fmt.Errorf("%s: %s: %s", key, message, value)
+		}
+	}
+
+	timeFilter := &AddTimeFilter{}
+	var formatter Formatter
+	format, _ := lookup(EnvFormat)
+	switch strings.ToLower(format) {
+	case "json":
+		timeFilter.TimeFormat = time.RFC3339
+		formatter = &JsonFormatter{
+			JsonEncoder: JsonEncoder{
+				LeadingKeys: StdLeadingKeys,
 			},
+		}
+	case "logfmt":
+		timeFilter.TimeFormat = time.RFC3339
+		formatter = &LogfmtFormatter{
+			LeadingKeys: StdLeadingKeys,
+		}
+	default:
+		if format != "" && strings.ToLower(format) != "console" {
+			fail(EnvFormat, format, "unknown format")
+		}
+		formatter = &ConsoleFormatter{
+			PrintTime: true,
+			PrintKeys: true,
+			SortKeys:  true,
+		}
+	}
+
+	if layout, ok := lookup(EnvTimeFormat); ok && layout != "" {
+		if epoch, ok := envEpochs[strings.ToLower(layout)]; ok {
+			if _, ok := formatter.(*ConsoleFormatter); ok {
+				// the console formatter only prints formatted times
+				fail(EnvTimeFormat, layout, "epoch timestamps require json or logfmt format")
+			} else {
+				timeFilter.Epoch = epoch
+			}
+		} else if predefined, ok := envTimeFormats[strings.ToLower(layout)]; ok {
+			timeFilter.TimeFormat = predefined
+		} else if (time.Time{}).Format(layout) == layout {
+			// a layout without any date or time elements is most likely a typo
+			fail(EnvTimeFormat, layout, "invalid time format")
+		} else {
+			timeFilter.TimeFormat = layout
+		}
+	}
+
+	var sink io.Writer = os.Stdout
+	var closers []io.Closer
+	switch output, _ := lookup(EnvOutput); output {
+	case "", "stdout":
+	case "stderr":
+		sink = os.Stderr
+	default:
+		file, err := openLogFile(output, false)
+		if err != nil {
+			fail(EnvOutput, output, err.Error())
+		} else {
+			sink = file
+			closers = append(closers, file)
+		}
+	}
+
+	var logger Filter = &MultiFilter{
+		Filters: []Filter{
+			timeFilter,
+		},
+		Logger: &Logger{
+			Formatter: formatter,
+			Sink:      sink,
 		},
 	}
+	if key, value, ok := envLevel(name, lookup); ok {
+		threshold, err := ParseLevel(value)
+		if err != nil {
+			fail(key, value, "invalid level")
+		} else {
+			logger = &LevelFilter{
+				Threshold: threshold,
+				Logger:    logger,
+			}
+		}
+	}
+	return &ConfigLogger{
+		StdLogger: StdLogger{
+			Logger: logger,
+		},
+		closers: closers,
+	}, first
+}
+
+// envLevel looks up the level for a named logger, falling back to EnvLevel.
+// Returns the variable that was found and its value.
+func envLevel(name string, lookup func(string) (string, bool)) (string, string, bool) {
+	if key, value, ok := envNamedLevel(name, lookup); ok {
+		return key, value, true
+	}
+	value, ok := lookup(EnvLevel)
+	return EnvLevel, value, ok && value != ""
+}
+
+// envNamedLevel looks up the level override for a named logger.
+func envNamedLevel(name string, lookup func(string) (string, bool)) (string, string, bool) {
+	if name == "" {
+		return "", "", false
+	}
+	sanitized := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	for _, candidate := range []string{name, sanitized, strings.ToUpper(sanitized)} {
+		key := EnvLevel + "_" + candidate
+		if value, ok := lookup(key); ok && value != "" {
+			return key, value, true
+		}
+	}
+	return "", "", false
 }
@@ -0,0 +1,141 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+
+	l01, err := newFromEnv("", envLookup(nil))
+	if err != nil {
+		t.Errorf("t01: unexpected error: %v", err)
+	}
+	m01 := l01.Logger.(*MultiFilter)
+	if _, ok := m01.Logger.(*Logger).Formatter.(*ConsoleFormatter); !ok || m01.Logger.(*Logger).Sink != os.Stdout {
+		t.Errorf("t01: should default to console output on stdout")
+	}
+
+	l02, err := newFromEnv("db", envLookup(map[string]string{
+		EnvFormat:        "logfmt",
+		EnvLevel:         "info",
+		EnvLevel + "_db": "warn",
+		EnvOutput:        path,
+		EnvTimeFormat:    "unixms",
+	}))
+	if err != nil {
+		t.Errorf("t02: unexpected error: %v", err)
+	}
+	if !levelEnabled(l02.Logger, LevelWarn) || levelEnabled(l02.Logger, LevelInfo) {
+		t.Errorf("t02: the named level should override the default level")
+	}
+	l02.Printr(Lvl(LevelInfo), String(StdMessageKey, "dropped"))
+	l02.Printr(Lvl(LevelError), String(StdMessageKey, "logged"))
+	l02.Logger.(*LevelFilter).Logger.(*MultiFilter).Logger.(*Logger).Sink.(*os.File).Close()
+	r02, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(r02)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "message=logged") {
+		t.Errorf("t02: invalid output: %q", r02)
+	}
+	if parsed := parseLogfmt(lines[0]); len(parsed[StdTimeKey].(string)) != 13 {
+		t.Errorf("t02: time should be in milliseconds: %q", lines[0])
+	}
+
+	l03, _ := newFromEnv("db.pool", envLookup(map[string]string{
+		EnvLevel + "_DB_POOL": "trace",
+		EnvLevel:              "error",
+	}))
+	if !levelEnabled(l03.Logger, LevelTrace) {
+		t.Errorf("t03: sanitized name should be accepted")
+	}
+
+	l04, err := newFromEnv("", envLookup(map[string]string{
+		EnvFormat:     "xml",
+		EnvLevel:      "loud",
+		EnvTimeFormat: "iso",
+	}))
+	if err == nil || !strings.Contains(err.Error(), EnvFormat) {
+		t.Errorf("t04: expected a format error, got %v", err)
+	}
+	if l04 == nil || l04.Logger.(*MultiFilter).Filters[0].(*AddTimeFilter).TimeFormat != "" {
+		t.Errorf("t04: invalid settings should fall back to defaults")
+	}
+
+	l05, _ := newFromEnv("", envLookup(map[string]string{
+		EnvFormat:     "json",
+		EnvTimeFormat: "kitchen",
+		EnvOutput:     "stderr",
+	}))
+	m05 := l05.Logger.(*MultiFilter)
+	if m05.Filters[0].(*AddTimeFilter).TimeFormat != "3:04PM" || m05.Logger.(*Logger).Sink != os.Stderr {
+		t.Errorf("t05: invalid configuration")
+	}
+	if _, ok := m05.Logger.(*Logger).Formatter.(*JsonFormatter); !ok {
+		t.Errorf("t05: expected a JsonFormatter")
+	}
+
+	l06, err := newFromEnv("", envLookup(map[string]string{
+		EnvTimeFormat: "unix",
+	}))
+	if err == nil || !strings.Contains(err.Error(), EnvTimeFormat) {
+		t.Errorf("t06: epoch timestamps should be rejected for console output, got %v", err)
+	}
+	if l06.Logger.(*MultiFilter).Filters[0].(*AddTimeFilter).Epoch != EpochNone {
+		t.Errorf("t06: console output should keep formatted times")
+	}
+
+	p07 := filepath.Join(dir, "test07.log")
+	l07, err := newFromEnv("", envLookup(map[string]string{
+		EnvOutput: p07,
+	}))
+	if err != nil {
+		t.Fatalf("t07: unexpected error: %v", err)
+	}
+	file := l07.Logger.(*MultiFilter).Logger.(*Logger).Sink.(*os.File)
+	if err := l07.Close(); err != nil {
+		t.Errorf("t07: unexpected error: %v", err)
+	}
+	if _, err := file.Write([]byte("closed\n")); err == nil {
+		t.Errorf("t07: the output file should be closed")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// DedupRepeatCountKey is the key that holds the number of suppressed
+	// repetitions in a record emitted by DedupFilter.
+	// Type: int
+	DedupRepeatCountKey = "repeat_count"
+	// DedupFirstSeenKey is the key that holds the time of the first
+	// occurrence of a repeated record.
+	// Type: time.Time
+	DedupFirstSeenKey = "first_seen"
+	// DedupLastSeenKey is the key that holds the time of the last
+	// occurrence of a repeated record.
+	// Type: time.Time
+	DedupLastSeenKey = "last_seen"
+	// DefaultDedupWindow is the deduplication window used when
+	// DedupFilter.Window is unset.
+	DefaultDedupWindow = 30 * time.Second
+)
+
+// DedupFilter collapses consecutive identical records, like syslogd's
+// "last message repeated N times".
+//
+// Two records are considered identical if their StdMessageKey values and
+// the values of all Keys are equal. The first record of a run is passed on
+// immediately, while repetitions are counted and suppressed. Once the window
+// expires, a different record arrives or Close is called, a copy of the last
+// repetition is sent on, with DedupRepeatCountKey, DedupFirstSeenKey and
+// DedupLastSeenKey added.
+//
+// The timestamps and the window are based on Clock. A timer flushes expired
+// runs only with the system clock; with any other Clock, like FakeClock,
+// expired runs are flushed when the next record arrives or Close is called.
+//
+// Since DedupFilter holds back records, it is not an in-place filter and
+// must be placed at the end of a chain, forwarding to Logger.
+type DedupFilter struct {
+	// Keys lists the keys that must match besides StdMessageKey.
+	Keys []string
+	// Window is the maximum duration of a run of repetitions.
+	// Defaults to DefaultDedupWindow if unset.
+	Window time.Duration
+	// Logger receives the deduplicated records.
+	Logger Filter
+	// Clock is the time source. Defaults to the system clock if unset.
+	Clock Clock
+
+	mutex  sync.Mutex
+	last   map[string]interface{}
+	count  int
+	first  time.Time
+	latest time.Time
+	timer  *time.Timer
+	// generation is incremented when a run ends, so a timer that fires
+	// after its run has ended does nothing.
+	generation int
+}
+
+func (filter *DedupFilter) window() time.Duration {
+	if filter.Window > 0 {
+		return filter.Window
+	}
+	return DefaultDedupWindow
+}
+
+func (filter *DedupFilter) same(kv map[string]interface{}) bool {
+	if filter.last == nil {
+		return false
+	}
+	if !reflect.DeepEqual(filter.last[StdMessageKey], kv[StdMessageKey]) {
+		return false
+	}
+	for _, k := range filter.Keys {
+		if !reflect.DeepEqual(filter.last[k], kv[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (filter *DedupFilter) Printd(kv map[string]interface{}) {
+	now := clockOrDefault(filter.Clock).Now()
+	filter.mutex.Lock()
+	if filter.same(kv) && now.Sub(filter.first) < filter.window() {
+		filter.last = copyDict(kv)
+		filter.latest = now
+		filter.count++
+		if filter.timer == nil && isSystemClock(filter.Clock) {
+			generation := filter.generation
+			filter.timer = time.AfterFunc(filter.first.Add(filter.window()).Sub(now), func() {
+				filter.expire(generation)
+			})
+		}
+		filter.mutex.Unlock()
+		return
+	}
+	summary := filter.flush()
+	filter.last = copyDict(kv)
+	filter.first = now
+	filter.latest = now
+	filter.mutex.Unlock()
+	// the Logger may be slow or log to this filter again
+	filter.forward(summary)
+	filter.forward(kv)
+}
+
+// Close flushes any pending repetitions.
+// The filter can still be used afterwards.
+func (filter *DedupFilter) Close() error {
+	filter.mutex.Lock()
+	summary := filter.flush()
+	filter.last = nil
+	filter.mutex.Unlock()
+	filter.forward(summary)
+	return nil
+}
+
+// expire is called by the window timer of a run.
+func (filter *DedupFilter) expire(generation int) {
+	filter.mutex.Lock()
+	if generation != filter.generation {
+		// the run has already ended
+		filter.mutex.Unlock()
+		return
+	}
+	filter.timer = nil
+	summary := filter.flush()
+	filter.last = nil
+	filter.mutex.Unlock()
+	filter.forward(summary)
+}
+
+// flush ends the current run and returns its summary, or nil if there
+// were no repetitions.
+// Must be called with the mutex held.
+func (filter *DedupFilter) flush() map[string]interface{} {
+	if filter.timer != nil {
+		filter.timer.Stop()
+		filter.timer = nil
+	}
+	filter.generation++
+	var summary map[string]interface{}
+	if filter.count > 0 {
+		summary = filter.last
+		summary[DedupRepeatCountKey] = filter.count
+		summary[DedupFirstSeenKey] = filter.first
+		summary[DedupLastSeenKey] = filter.latest
+	}
+	filter.count = 0
+	return summary
+}
+
+func (filter *DedupFilter) forward(kv map[string]interface{}) {
+	if kv != nil && filter.Logger != nil {
+		filter.Logger.Printd(kv)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordFilter records all dictionaries it receives.
+type recordFilter struct {
+	records []map[string]interface{}
+}
+
+func (filter *recordFilter) Printd(kv map[string]interface{}) {
+	filter.records = append(filter.records, kv)
+}
+
+// lockedRecordFilter is a recordFilter that can be used concurrently.
+type lockedRecordFilter struct {
+	mutex   sync.Mutex
+	records []map[string]interface{}
+}
+
+func (filter *lockedRecordFilter) Printd(kv map[string]interface{}) {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+	filter.records = append(filter.records, kv)
+}
+
+func (filter *lockedRecordFilter) Len() int {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+	return len(filter.records)
+}
+
+// loopFilter logs another record to loop when it receives trigger.
+type loopFilter struct {
+	loop    Filter
+	trigger string
+	Logger  Filter
+}
+
+func (filter *loopFilter) Printd(kv map[string]interface{}) {
+	filter.Logger.Printd(kv)
+	if kv[StdMessageKey] == filter.trigger {
+		filter.loop.Printd(map[string]interface{}{StdMessageKey: filter.trigger + "-loop"})
+	}
+}
+
+func TestDedupFilter(t *testing.T) {
+	r01 := &recordFilter{}
+	f01 := &DedupFilter{
+		Window: time.Hour,
+		Logger: r01,
+	}
+	f01.Printd(map[string]interface{}{"message": "test01"})
+	f01.Printd(map[string]interface{}{"message": "test01"})
+	f01.Printd(map[string]interface{}{"message": "test01"})
+	if len(r01.records) != 1 {
+		t.Errorf("t01: repetitions were not suppressed")
+	}
+	f01.Close()
+	if len(r01.records) != 2 {
+		t.Fatalf("t01: summary was not flushed on close")
+	}
+	if r01.records[1]["message"] != "test01" || r01.records[1][DedupRepeatCountKey] != 2 {
+		t.Errorf("t01: invalid summary: %v", r01.records[1])
+	}
+	first, ok1 := r01.records[1][DedupFirstSeenKey].(time.Time)
+	last, ok2 := r01.records[1][DedupLastSeenKey].(time.Time)
+	if !ok1 || !ok2 || last.Before(first) {
+		t.Errorf("t01: invalid timestamps: %v", r01.records[1])
+	}
+
+	r02 := &recordFilter{}
+	f02 := &DedupFilter{
+		Window: time.Hour,
+		Logger: r02,
+	}
+	f02.Printd(map[string]interface{}{"message": "test02a"})
+	f02.Printd(map[string]interface{}{"message": "test02a"})
+	f02.Printd(map[string]interface{}{"message": "test02b"})
+	if len(r02.records) != 3 || r02.records[1][DedupRepeatCountKey] != 1 || r02.records[2]["message"] != "test02b" {
+		t.Errorf("t02: summary was not flushed on a different message: %v", r02.records)
+	}
+	f02.Close()
+	if len(r02.records) != 3 {
+		t.Errorf("t02: unexpected summary without repetitions")
+	}
+
+	r03 := &recordFilter{}
+	f03 := &DedupFilter{
+		Keys:   []string{"key03"},
+		Window: time.Hour,
+		Logger: r03,
+	}
+	f03.Printd(map[string]interface{}{"message": "test03", "key03": 1, "other": 1})
+	f03.Printd(map[string]interface{}{"message": "test03", "key03": 1, "other": 2})
+	f03.Printd(map[string]interface{}{"message": "test03", "key03": 2})
+	if len(r03.records) != 3 || r03.records[1]["other"] != 2 || r03.records[2]["key03"] != 2 {
+		t.Errorf("t03: selected keys were not compared: %v", r03.records)
+	}
+
+	r04 := &lockedRecordFilter{}
+	f04 := &DedupFilter{
+		Window: 10 * time.Millisecond,
+		Logger: r04,
+	}
+	f04.Printd(map[string]interface{}{"message": "test04"})
+	f04.Printd(map[string]interface{}{"message": "test04"})
+	time.Sleep(50 * time.Millisecond)
+	if r04.Len() != 2 {
+		t.Errorf("t04: summary was not flushed on window expiry")
+	}
+	f04.Printd(map[string]interface{}{"message": "test04"})
+	if r04.Len() != 3 || r04.records[2][DedupRepeatCountKey] != nil {
+		t.Errorf("t04: new run was not started after expiry")
+	}
+
+	now := time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC)
+	c05 := NewFakeClock(now)
+	r05 := &recordFilter{}
+	f05 := &DedupFilter{
+		Window: time.Minute,
+		Logger: r05,
+		Clock:  c05,
+	}
+	f05.Printd(map[string]interface{}{"message": "test05"})
+	c05.Advance(10 * time.Second)
+	f05.Printd(map[string]interface{}{"message": "test05"})
+	f05.mutex.Lock()
+	armed05 := f05.timer != nil
+	f05.mutex.Unlock()
+	if armed05 {
+		t.Errorf("t05: no timer should be armed with a fake clock")
+	}
+	c05.Advance(10 * time.Second)
+	f05.Printd(map[string]interface{}{"message": "test05"})
+	c05.Advance(time.Minute)
+	f05.Printd(map[string]interface{}{"message": "test05"})
+	f05.Close()
+	if len(r05.records) != 3 {
+		t.Fatalf("t05: invalid number of records: %v", r05.records)
+	}
+	if r05.records[1][DedupRepeatCountKey] != 2 || r05.records[1][DedupFirstSeenKey] != now || r05.records[1][DedupLastSeenKey] != now.Add(20*time.Second) {
+		t.Errorf("t05: invalid summary: %v", r05.records[1])
+	}
+	if r05.records[2][DedupRepeatCountKey] != nil {
+		t.Errorf("t05: new run was not started after expiry")
+	}
+
+	r06 := &recordFilter{}
+	f06 := &DedupFilter{
+		Window: time.Hour,
+		Logger: r06,
+	}
+	f06.Printd(map[string]interface{}{"message": "test06a"})
+	f06.Printd(map[string]interface{}{"message": "test06a"})
+	f06.mutex.Lock()
+	stale06 := f06.generation
+	f06.mutex.Unlock()
+	f06.Printd(map[string]interface{}{"message": "test06b"})
+	f06.Printd(map[string]interface{}{"message": "test06b"})
+	// a timer of the first run that fired too late
+	f06.expire(stale06)
+	if len(r06.records) != 3 {
+		t.Errorf("t06: a stale timer flushed the next run: %v", r06.records)
+	}
+	f06.Close()
+	if len(r06.records) != 4 || r06.records[3][DedupRepeatCountKey] != 1 {
+		t.Errorf("t06: invalid summary: %v", r06.records)
+	}
+
+	r07 := &recordFilter{}
+	f07 := &DedupFilter{
+		Window: time.Hour,
+	}
+	// a logger that logs to the filter again must not deadlock
+	f07.Logger = &loopFilter{
+		loop:    f07,
+		trigger: "test07a",
+		Logger:  r07,
+	}
+	f07.Printd(map[string]interface{}{"message": "test07a"})
+	if len(r07.records) != 2 || r07.records[1]["message"] != "test07a-loop" {
+		t.Errorf("t07: invalid records: %v", r07.records)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// ErrorKey is the key used by Err.
+	// Type: error
+	ErrorKey = "error"
+)
+
+var (
+	// minFieldTime and maxFieldTime are the limits of times that can be
+	// represented as Unix nanoseconds.
+	minFieldTime = time.Unix(0, math.MinInt64)
+	maxFieldTime = time.Unix(0, math.MaxInt64)
+)
+
+// FieldType determines how the value of a Field is stored.
+type FieldType uint8
+
+const (
+	// FieldAny stores an arbitrary value in Interface.
+	FieldAny FieldType = iota
+	// FieldString stores a string in String.
+	FieldString
+	// FieldBool stores 0 or 1 in Integer.
+	FieldBool
+	// FieldInt stores an int in Integer.
+	FieldInt
+	// FieldInt64 stores an int64 in Integer.
+	FieldInt64
+	// FieldUint64 stores the bits of a uint64 in Integer.
+	FieldUint64
+	// FieldFloat64 stores the bits of a float64 in Integer.
+	FieldFloat64
+	// FieldDuration stores a time.Duration in Integer.
+	FieldDuration
+	// FieldTime stores Unix nanoseconds in Integer and the
+	// *time.Location in Interface.
+	FieldTime
+	// FieldError stores an error in Interface.
+	FieldError
+	// FieldLevel stores a Level in Integer.
+	FieldLevel
+)
+
+// Field is a typed key-value pair.
+//
+// Fields are the allocation-free alternative to the interleaved key-value
+// lists accepted by StdLogger.Printkv: The constructors store common value
+// types without boxing them into an interface{}.
+type Field struct {
+	Key       string
+	Type      FieldType
+	Integer   int64
+	String    string
+	Interface interface{}
+}
+
+// Any creates a field with an arbitrary value.
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Type: FieldAny, Interface: value}
+}
+
+// String creates a string field.
+func String(key string, value string) Field {
+	return Field{Key: key, Type: FieldString, String: value}
+}
+
+// Bool creates a boolean field.
+func Bool(key string, value bool) Field {
+	var i int64
+	if value {
+		i = 1
+	}
+	return Field{Key: key, Type: FieldBool, Integer: i}
+}
+
+// Int creates an int field.
+func Int(key string, value int) Field {
+	return Field{Key: key, Type: FieldInt, Integer: int64(value)}
+}
+
+// Int64 creates an int64 field.
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Type: FieldInt64, Integer: value}
+}
+
+// Uint64 creates a uint64 field.
+func Uint64(key string, value uint64) Field {
+	return Field{Key: key, Type: FieldUint64, Integer: int64(value)}
+}
+
+// Float64 creates a float64 field.
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Type: FieldFloat64, Integer: int64(math.Float64bits(value))}
+}
+
+// Duration creates a time.Duration field.
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Type: FieldDuration, Integer: int64(value)}
+}
+
+// Time creates a time.Time field.
+// Times that cannot be represented as Unix nanoseconds are stored as
+// FieldAny. The monotonic clock reading is not preserved.
+func Time(key string, value time.Time) Field {
+	if value.Before(minFieldTime) || value.After(maxFieldTime) {
+		return Any(key, value)
+	}
+	return Field{Key: key, Type: FieldTime, Integer: value.UnixNano(), Interface: value.Location()}
+}
+
+// Err creates a field with the key ErrorKey.
+// A nil error is stored as a nil FieldAny.
+func Err(err error) Field {
+	return NamedErr(ErrorKey, err)
+}
+
+// NamedErr creates an error field with a custom key.
+func NamedErr(key string, err error) Field {
+	if err == nil {
+		return Any(key, nil)
+	}
+	return Field{Key: key, Type: FieldError, Interface: err}
+}
+
+// Lvl creates a field with the key LevelKey.
+func Lvl(level Level) Field {
+	return Field{Key: LevelKey, Type: FieldLevel, Integer: int64(level)}
+}
+
+// Value returns the value of the field as an interface{}.
+// Note that this may allocate memory.
+func (field Field) Value() interface{} {
+	switch field.Type {
+	case FieldString:
+		return field.String
+	case FieldBool:
+		return field.Integer != 0
+	case FieldInt:
+		return int(field.Integer)
+	case FieldInt64:
+		return field.Integer
+	case FieldUint64:
+		return uint64(field.Integer)
+	case FieldFloat64:
+		return math.Float64frombits(uint64(field.Integer))
+	case FieldDuration:
+		return time.Duration(field.Integer)
+	case FieldTime:
+		return field.time()
+	case FieldLevel:
+		return Level(field.Integer)
+	default:
+		return field.Interface
+	}
+}
+
+func (field Field) time() time.Time {
+	t := time.Unix(0, field.Integer)
+	if loc, ok := field.Interface.(*time.Location); ok && loc != nil {
+		return t.In(loc)
+	}
+	return t.UTC()
+}
+
+// level extracts a Level from a field, if it contains one.
+func (field Field) level() (Level, bool) {
+	if field.Type == FieldLevel {
+		return Level(field.Integer), true
+	}
+	if field.Type == FieldString {
+		level, err := ParseLevel(field.String)
+		return level, err == nil
+	}
+	return LevelOf(field.Interface)
+}
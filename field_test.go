@@ -0,0 +1,201 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFieldValue(t *testing.T) {
+	err := errors.New("test")
+	now := time.Date(2018, 1, 31, 8, 59, 2, 1234, time.UTC)
+	fields := []Field{
+		Any("any", []int{1}),
+		String("string", "value"),
+		Bool("bool", true),
+		Int("int", -42),
+		Int64("int64", 1<<40),
+		Uint64("uint64", 1<<63),
+		Float64("float64", 0.5),
+		Duration("duration", time.Second),
+		Time("time", now),
+		Time("zero", time.Time{}),
+		Err(err),
+		Err(nil),
+		Lvl(LevelWarn),
+	}
+	expected := []interface{}{
+		[]int{1},
+		"value",
+		true,
+		-42,
+		int64(1 << 40),
+		uint64(1 << 63),
+		0.5,
+		time.Second,
+		now,
+		time.Time{},
+		err,
+		nil,
+		LevelWarn,
+	}
+	for i, field := range fields {
+		if !reflect.DeepEqual(field.Value(), expected[i]) {
+			t.Errorf("t%02d: invalid value for %s: %v", i+1, field.Key, field.Value())
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	r01 := newRecord([]Field{String("message", "test01"), Int("count", 1)})
+	r01.Set(Int("count", 2))
+	r01.Set(Bool("flag", true))
+	if f, ok := r01.Lookup("count"); !ok || f.Value() != 2 || r01.Len() != 3 {
+		t.Errorf("t01: field not replaced")
+	}
+	d01 := r01.Dict()
+	x01 := map[string]interface{}{"message": "test01", "count": 2, "flag": true}
+	if !reflect.DeepEqual(d01, x01) {
+		t.Errorf("t01: invalid dictionary: %v", d01)
+	}
+	d01["count"] = 3
+	if f, ok := r01.Lookup("count"); !ok || f.Value() != 3 {
+		t.Errorf("t01: dictionary modification not reflected")
+	}
+	r01.release()
+	if r01.Len() != 0 {
+		t.Errorf("t01: released record not empty")
+	}
+	r02 := newRecord([]Field{String("message", "test02"), Int("count", 1), Int("count", 2)})
+	r02.Set(Int("count", 3))
+	var e02 []Field
+	r02.Each(func(field Field) {
+		e02 = append(e02, field)
+	})
+	if len(e02) != 2 || e02[1].Value() != 3 || r02.Len() != 2 {
+		t.Errorf("t02: the last duplicate should be replaced: %v", e02)
+	}
+	j02, _ := (&JsonEncoder{KeyOrder: KeyOrderNone}).AppendRecord(nil, r02)
+	if string(j02) != `{"message":"test02","count":3}` {
+		t.Errorf("t02: duplicate keys should be encoded once: %s", j02)
+	}
+	d02 := r02.Dict()
+	if !reflect.DeepEqual(d02, map[string]interface{}{"message": "test02", "count": 3}) {
+		t.Errorf("t02: invalid dictionary: %v", d02)
+	}
+	r02.release()
+}
+
+func TestStdLoggerPrintr(t *testing.T) {
+	now := time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC)
+	r01 := &recordFilter{}
+	l01 := &StdLogger{
+		Logger: &MultiFilter{
+			Filters: []Filter{
+				&AddTimeFilter{
+					Clock: NewFakeClock(now),
+				},
+			},
+			Logger: &LevelFilter{
+				Threshold: LevelInfo,
+				Logger:    r01,
+			},
+		},
+	}
+	l01.Printr(Lvl(LevelDebug), String("message", "test01a"))
+	l01.Printr(Lvl(LevelInfo), String("message", "test01b"), Int("count", 1))
+	x01 := map[string]interface{}{"level": LevelInfo, "message": "test01b", "count": 1, "time": now}
+	if len(r01.records) != 1 || !reflect.DeepEqual(r01.records[0], x01) {
+		t.Errorf("t01: invalid records: %v", r01.records)
+	}
+
+	b02 := &bytes.Buffer{}
+	l02 := &StdLogger{
+		Logger: &Logger{
+			Sink: b02,
+		},
+	}
+	l02.Printr(String("message", "test02"))
+	if b02.String() != "test02" {
+		t.Errorf("t02: invalid output: %s", b02)
+	}
+}
+
+func TestStdLoggerPrintrAllocs(t *testing.T) {
+	l01 := &StdLogger{
+		Logger: &MultiFilter{
+			Filters: []Filter{
+				&AddTimeFilter{},
+			},
+			Logger: &LevelFilter{
+				Threshold: LevelInfo,
+				Logger: &Logger{
+					Sink: ioutil.Discard,
+				},
+			},
+		},
+	}
+	a01 := testing.AllocsPerRun(100, func() {
+		l01.Printr(Lvl(LevelDebug), String("message", "test01"), Int("count", 1000))
+	})
+	if a01 != 0 {
+		t.Errorf("t01: disabled level allocated %v times", a01)
+	}
+	a02 := testing.AllocsPerRun(100, func() {
+		l01.Printr(Lvl(LevelInfo), String("message", "test02"), Int("count", 1000), Duration("elapsed", time.Second))
+	})
+	if a02 > 1 && !raceEnabled {
+		t.Errorf("t02: enabled level allocated %v times", a02)
+	}
+}
+
+func BenchmarkPrintkv(b *testing.B) {
+	l := &StdLogger{
+		Logger: &Logger{
+			Sink: ioutil.Discard,
+		},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Printkv("message", "benchmark", "count", i, "elapsed", time.Second)
+	}
+}
+
+func BenchmarkPrintr(b *testing.B) {
+	l := &StdLogger{
+		Logger: &Logger{
+			Sink: ioutil.Discard,
+		},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Printr(String("message", "benchmark"), Int("count", i), Duration("elapsed", time.Second))
+	}
+}
@@ -34,17 +34,42 @@ const (
 	filterListAllocation = 10
 )
 
+// EpochUnit selects the resolution of Unix timestamps produced by AddTimeFilter.
+type EpochUnit int
+
+const (
+	// EpochNone disables Unix timestamps.
+	EpochNone EpochUnit = iota
+	// EpochSeconds produces seconds since the Unix epoch.
+	EpochSeconds
+	// EpochMillis produces milliseconds since the Unix epoch.
+	EpochMillis
+	// EpochNanos produces nanoseconds since the Unix epoch.
+	EpochNanos
+)
+
 // AddTimeFilter adds StdTimeKey, containing the current local time as
 // a time.Time object.
 // If TimeFormat is set, the current time will be formatted according to
 // this format.
+// If Epoch is set, the current time will be converted to an int64 Unix
+// timestamp instead, and TimeFormat is ignored.
 // If StdTimeKey is already present and a string, it will not be modified.
-// If it is present but a time.Time object and TimeFormat is set, it
-// will be formatted according to this format.
+// If it is present but a time.Time object, it will be converted according
+// to the settings above.
 // If it is present but of a different type, it will be treated like it
 // wasn't present.
 type AddTimeFilter struct {
 	TimeFormat string
+	// Clock is the time source. Defaults to the system clock if unset.
+	Clock Clock
+	// UTC converts times to UTC.
+	UTC bool
+	// Truncate rounds times down to a multiple of this duration,
+	// for example time.Millisecond.
+	Truncate time.Duration
+	// Epoch selects a Unix timestamp instead of a time.Time or string.
+	Epoch EpochUnit
 }
 
 func (filter *AddTimeFilter) Printd(kv map[string]interface{}) {
@@ -52,18 +77,52 @@ func (filter *AddTimeFilter) Printd(kv map[string]interface{}) {
 	case string:
 		// pass
 	case time.Time:
-		if filter.TimeFormat != "" {
-			kv[StdTimeKey] = t.Format(filter.TimeFormat)
-		}
+		kv[StdTimeKey] = filter.convert(t).Value()
 	default:
-		if filter.TimeFormat != "" {
-			kv[StdTimeKey] = time.Now().Format(filter.TimeFormat)
-		} else {
-			kv[StdTimeKey] = time.Now()
+		kv[StdTimeKey] = filter.convert(clockOrDefault(filter.Clock).Now()).Value()
+	}
+}
+
+func (filter *AddTimeFilter) Printr(record *Record) {
+	field, _ := record.Lookup(StdTimeKey)
+	switch field.Type {
+	case FieldString:
+		// pass
+	case FieldTime:
+		record.Set(filter.convert(field.time()))
+	default:
+		switch t := field.Interface.(type) {
+		case string:
+			// pass
+		case time.Time:
+			record.Set(filter.convert(t))
+		default:
+			record.Set(filter.convert(clockOrDefault(filter.Clock).Now()))
 		}
 	}
 }
 
+func (filter *AddTimeFilter) convert(t time.Time) Field {
+	if filter.UTC {
+		t = t.UTC()
+	}
+	if filter.Truncate > 0 {
+		t = t.Truncate(filter.Truncate)
+	}
+	switch filter.Epoch {
+	case EpochSeconds:
+		return Int64(StdTimeKey, t.Unix())
+	case EpochMillis:
+		return Int64(StdTimeKey, t.UnixNano()/int64(time.Millisecond))
+	case EpochNanos:
+		return Int64(StdTimeKey, t.UnixNano())
+	}
+	if filter.TimeFormat != "" {
+		return String(StdTimeKey, t.Format(filter.TimeFormat))
+	}
+	return Time(StdTimeKey, t)
+}
+
 // MergeFilter merges a constant dictionary with anything that is being logged.
 // Existing values are not replaced.
 //
@@ -92,6 +151,22 @@ func (filter *MultiFilter) Printd(kv map[string]interface{}) {
 	for _, f := range filter.Filters {
 		f.Printd(kv)
 	}
+	if filter.Logger != nil {
+		filter.Logger.Printd(kv)
+	}
+}
+
+func (filter *MultiFilter) Printr(record *Record) {
+	for _, f := range filter.Filters {
+		printRecord(f, record)
+	}
+	printRecord(filter.Logger, record)
+}
+
+// LevelEnabled asks the Logger whether a level is enabled.
+// The Filters are not consulted.
+func (filter *MultiFilter) LevelEnabled(level Level) bool {
+	return levelEnabled(filter.Logger, level)
 }
 
 // AddFilter appends a filter to the end of the list.
@@ -110,3 +185,18 @@ func (filter *MultiFilter) ClearFilters() {
 	// allocate with default size
 	filter.Filters = make([]Filter, 0, filterListAllocation)
 }
+
+// BranchFilter sends a separate copy of each dictionary to every Logger.
+// Use it to feed several filter chains with different output requirements.
+//
+// Only the top-level dictionary is copied. Do NOT do deep modifications of
+// values in the branches.
+type BranchFilter struct {
+	Loggers []Filter
+}
+
+func (filter *BranchFilter) Printd(kv map[string]interface{}) {
+	for _, f := range filter.Loggers {
+		f.Printd(copyDict(kv))
+	}
+}
@@ -34,28 +34,36 @@ func TestAddTimeFilter(t *testing.T) {
 		t.Error("t00: time key should be named 'time'")
 	}
 
+	now := time.Date(2018, 1, 31, 9, 10, 11, 123456789, time.FixedZone("test", 3600))
+	clock := NewFakeClock(now)
+
 	c01 := map[string]interface{}{}
-	f01 := &AddTimeFilter{}
+	f01 := &AddTimeFilter{
+		Clock: clock,
+	}
 	f01.Printd(c01)
-	if _, ok := c01[StdTimeKey].(time.Time); !ok {
-		t.Errorf("t01: invalid type for time key")
+	if r01, ok := c01[StdTimeKey].(time.Time); !ok || !r01.Equal(now) {
+		t.Errorf("t01: invalid time: %v", c01[StdTimeKey])
 	}
 
 	c02 := map[string]interface{}{}
 	f02 := &AddTimeFilter{
 		TimeFormat: time.RFC3339,
+		Clock:      clock,
 	}
 	f02.Printd(c02)
-	if _, ok := c02[StdTimeKey].(string); !ok {
-		t.Error("t02: invalid type for time key")
+	if c02[StdTimeKey] != "2018-01-31T09:10:11+01:00" {
+		t.Errorf("t02: invalid time: %v", c02[StdTimeKey])
 	}
 
 	c03 := map[string]interface{}{
 		"message": "test03",
 	}
-	f03 := &AddTimeFilter{}
+	f03 := &AddTimeFilter{
+		Clock: clock,
+	}
 	f03.Printd(c03)
-	if _, ok := c03[StdTimeKey].(time.Time); !ok || c03["message"] != "test03" {
+	if r03, ok := c03[StdTimeKey].(time.Time); !ok || !r03.Equal(now) || c03["message"] != "test03" {
 		t.Error("t03: invalid type for time key")
 	}
 
@@ -63,9 +71,10 @@ func TestAddTimeFilter(t *testing.T) {
 	c04 := map[string]interface{}{
 		StdTimeKey: t04,
 	}
-	f04 := &AddTimeFilter{}
-	// wait for a time change
-	time.Sleep(1)
+	f04 := &AddTimeFilter{
+		Clock: clock,
+	}
+	clock.Advance(time.Second)
 	f04.Printd(c04)
 	r04, ok := c04[StdTimeKey].(time.Time)
 	if !ok {
@@ -81,9 +90,9 @@ func TestAddTimeFilter(t *testing.T) {
 	}
 	f05 := &AddTimeFilter{
 		TimeFormat: time.RFC3339,
+		Clock:      clock,
 	}
-	// wait for a time change
-	time.Sleep(1)
+	clock.Advance(time.Second)
 	f05.Printd(c05)
 	r05, ok := c05[StdTimeKey].(string)
 	if !ok {
@@ -92,6 +101,52 @@ func TestAddTimeFilter(t *testing.T) {
 	if r05 != t05.Format(time.RFC3339) {
 		t.Error("t05: time does not match")
 	}
+
+	clock.Set(now)
+
+	c06 := map[string]interface{}{}
+	f06 := &AddTimeFilter{
+		TimeFormat: time.RFC3339Nano,
+		Clock:      clock,
+		UTC:        true,
+		Truncate:   time.Millisecond,
+	}
+	f06.Printd(c06)
+	if c06[StdTimeKey] != "2018-01-31T08:10:11.123Z" {
+		t.Errorf("t06: invalid time: %v", c06[StdTimeKey])
+	}
+
+	c07 := map[string]interface{}{}
+	f07 := &AddTimeFilter{
+		TimeFormat: time.RFC3339,
+		Clock:      clock,
+		Epoch:      EpochSeconds,
+	}
+	f07.Printd(c07)
+	if c07[StdTimeKey] != now.Unix() {
+		t.Errorf("t07: invalid time: %v", c07[StdTimeKey])
+	}
+
+	c08 := map[string]interface{}{}
+	f08 := &AddTimeFilter{
+		Clock: clock,
+		Epoch: EpochMillis,
+	}
+	f08.Printd(c08)
+	if c08[StdTimeKey] != now.Unix()*1000+123 {
+		t.Errorf("t08: invalid time: %v", c08[StdTimeKey])
+	}
+
+	c09 := map[string]interface{}{
+		StdTimeKey: now,
+	}
+	f09 := &AddTimeFilter{
+		Epoch: EpochNanos,
+	}
+	f09.Printd(c09)
+	if c09[StdTimeKey] != now.UnixNano() {
+		t.Errorf("t09: invalid time: %v", c09[StdTimeKey])
+	}
 }
 
 func TestMergeFilter(t *testing.T) {
@@ -242,4 +297,51 @@ func TestMultiFilter(t *testing.T) {
 	if len(f03.Filters) != 1 || !arrayContains(f03.Filters, f03c) {
 		t.Errorf("t03: list wasn't cleared before adding")
 	}
+
+	r04 := &recordFilter{}
+	f04 := &MultiFilter{
+		Filters: []Filter{
+			&MergeFilter{
+				Dict: map[string]interface{}{
+					"key04b": "value04b",
+				},
+			},
+		},
+		Logger: r04,
+	}
+	f04.Printd(map[string]interface{}{
+		"key04a": "value04a",
+	})
+	if len(r04.records) != 1 || r04.records[0]["key04a"] != "value04a" || r04.records[0]["key04b"] != "value04b" {
+		t.Errorf("t04: filtered dict wasn't passed to the logger: %v", r04.records)
+	}
+}
+
+func TestBranchFilter(t *testing.T) {
+	r01a := &recordFilter{}
+	r01b := &recordFilter{}
+	f01 := &BranchFilter{
+		Loggers: []Filter{
+			&MultiFilter{
+				Filters: []Filter{
+					&TransformFilter{
+						Rules: []TransformRule{
+							{Op: TransformRename, Keys: []string{"message"}, Target: "msg"},
+						},
+					},
+				},
+				Logger: r01a,
+			},
+			r01b,
+		},
+	}
+	f01.Printd(map[string]interface{}{
+		"message": "test01",
+	})
+	if len(r01a.records) != 1 || r01a.records[0]["msg"] != "test01" {
+		t.Errorf("t01: first branch was not transformed")
+	}
+	if len(r01b.records) != 1 || r01b.records[0]["message"] != "test01" || len(r01b.records[0]) != 1 {
+		t.Errorf("t01: second branch was modified")
+	}
 }
@@ -0,0 +1,267 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultFlattenSeparator is the separator for dotted keys.
+	DefaultFlattenSeparator = "."
+)
+
+// FlattenStyle determines how nested keys are joined.
+type FlattenStyle int
+
+const (
+	// FlattenDotted joins keys with a separator: http.request.method, tags.0
+	FlattenDotted FlattenStyle = iota
+	// FlattenBracketed puts nested keys in brackets: http[request][method], tags[0]
+	FlattenBracketed
+)
+
+// flattenKeys holds the options shared by FlattenFilter and UnflattenFilter.
+type flattenKeys struct {
+	style     FlattenStyle
+	separator string
+}
+
+func (keys flattenKeys) join(prefix, key string) string {
+	if keys.style == FlattenBracketed {
+		return prefix + "[" + key + "]"
+	}
+	return prefix + stringOrDefault(keys.separator, DefaultFlattenSeparator) + key
+}
+
+// split splits a key into at most limit+1 segments.
+// A limit of 0 means no limit.
+func (keys flattenKeys) split(key string, limit int) []string {
+	if keys.style == FlattenBracketed {
+		open := strings.IndexByte(key, '[')
+		if open <= 0 || !strings.HasSuffix(key, "]") {
+			return []string{key}
+		}
+		inner := strings.Split(key[open+1:len(key)-1], "][")
+		for _, segment := range inner {
+			if strings.ContainsAny(segment, "[]") {
+				return []string{key}
+			}
+		}
+		if limit > 0 && len(inner) > limit {
+			rest := inner[limit-1]
+			for _, segment := range inner[limit:] {
+				rest += "[" + segment + "]"
+			}
+			inner = append(inner[:limit-1], rest)
+		}
+		return append([]string{key[:open]}, inner...)
+	}
+	n := -1
+	if limit > 0 {
+		n = limit + 1
+	}
+	return strings.SplitN(key, stringOrDefault(keys.separator, DefaultFlattenSeparator), n)
+}
+
+// FlattenFilter converts nested dictionaries, lists and LogMarshalers into
+// flat keys, for output formats that don't support nesting.
+//
+// For example, {"http": {"request": {"method": "GET"}}} becomes
+// {"http.request.method": "GET"} with the dotted style, or
+// {"http[request][method]": "GET"} with the bracketed style.
+// List elements use their index as the key.
+//
+// Supported nested types are map[string]interface{}, []interface{},
+// []string and LogMarshalers. Empty dictionaries and lists are left as-is.
+// If a flattened key collides with an existing key, the flattened value wins.
+type FlattenFilter struct {
+	// Style selects dotted or bracketed keys.
+	Style FlattenStyle
+	// Separator is used to join dotted keys.
+	// Defaults to DefaultFlattenSeparator if unset.
+	Separator string
+	// MaxDepth is the maximum number of levels to flatten.
+	// Deeper values are kept as they are. 0 means no limit.
+	MaxDepth int
+}
+
+func (filter *FlattenFilter) Printd(kv map[string]interface{}) {
+	var nested []string
+	for k, v := range kv {
+		if isNested(v) {
+			nested = append(nested, k)
+		}
+	}
+	keys := flattenKeys{filter.Style, filter.Separator}
+	for _, k := range nested {
+		v := kv[k]
+		delete(kv, k)
+		filter.flatten(kv, keys, k, v, 1)
+	}
+}
+
+func (filter *FlattenFilter) flatten(kv map[string]interface{}, keys flattenKeys, prefix string, v interface{}, depth int) {
+	if !isNested(v) || (filter.MaxDepth > 0 && depth > filter.MaxDepth) || depth > maxJsonDepth {
+		kv[prefix] = v
+		return
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			filter.flatten(kv, keys, keys.join(prefix, k), e, depth+1)
+		}
+	case []interface{}:
+		for i, e := range t {
+			filter.flatten(kv, keys, keys.join(prefix, strconv.Itoa(i)), e, depth+1)
+		}
+	case []string:
+		for i, e := range t {
+			kv[keys.join(prefix, strconv.Itoa(i))] = e
+		}
+	default:
+		marshalLog(v, func(field Field) {
+			filter.flatten(kv, keys, keys.join(prefix, field.Key), field.Value(), depth+1)
+		})
+	}
+}
+
+// isNested checks if a value can be flattened.
+func isNested(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return len(t) > 0
+	case []interface{}:
+		return len(t) > 0
+	case []string:
+		return len(t) > 0
+	default:
+		return hasMarshaler(v)
+	}
+}
+
+// UnflattenFilter is the reverse of FlattenFilter: It converts flat keys
+// into nested dictionaries, for output formats that support nesting.
+//
+// Nested dictionaries whose keys are exactly 0 to n-1 are converted into
+// lists. If a key conflicts with an existing non-dictionary value, for
+// example "a" and "a.b", it is left as it is.
+type UnflattenFilter struct {
+	// Style selects dotted or bracketed keys.
+	Style FlattenStyle
+	// Separator is used to split dotted keys.
+	// Defaults to DefaultFlattenSeparator if unset.
+	Separator string
+	// MaxDepth is the maximum number of levels to create.
+	// The remainder of a key is used as-is. 0 means no limit.
+	MaxDepth int
+}
+
+func (filter *UnflattenFilter) Printd(kv map[string]interface{}) {
+	keys := flattenKeys{filter.Style, filter.Separator}
+	// process keys in order, so conflicts are resolved deterministically
+	created := make(map[string]bool)
+	copied := make(map[string]bool)
+	for _, k := range OrderedStringKeys(kv) {
+		segments := keys.split(k, filter.MaxDepth)
+		if len(segments) < 2 {
+			continue
+		}
+		if nested, ok := kv[segments[0]].(map[string]interface{}); ok && !copied[segments[0]] {
+			// existing dictionaries may be shared with other records
+			kv[segments[0]] = copyNested(nested)
+			copied[segments[0]] = true
+		}
+		if insertNested(kv, segments, kv[k]) {
+			delete(kv, k)
+			created[segments[0]] = true
+		}
+	}
+	for k := range created {
+		kv[k] = listify(kv[k])
+	}
+}
+
+// insertNested stores value at the path given by segments, creating
+// dictionaries as needed. Returns false if there is a conflict.
+func insertNested(kv map[string]interface{}, segments []string, value interface{}) bool {
+	node := kv
+	for i, segment := range segments[:len(segments)-1] {
+		child, ok := node[segment]
+		if !ok {
+			// check for conflicts further down before modifying anything
+			created := make(map[string]interface{})
+			if !insertNested(created, segments[i+1:], value) {
+				return false
+			}
+			node[segment] = created
+			return true
+		}
+		next, ok := child.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		node = next
+	}
+	last := segments[len(segments)-1]
+	if _, ok := node[last]; ok {
+		return false
+	}
+	node[last] = value
+	return true
+}
+
+// copyNested copies a dictionary and all dictionaries nested in it.
+func copyNested(dict map[string]interface{}) map[string]interface{} {
+	dup := make(map[string]interface{}, len(dict))
+	for k, v := range dict {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = copyNested(nested)
+		}
+		dup[k] = v
+	}
+	return dup
+}
+
+// listify recursively converts dictionaries with keys 0 to n-1 into lists.
+func listify(v interface{}) interface{} {
+	dict, ok := v.(map[string]interface{})
+	if !ok || len(dict) == 0 {
+		return v
+	}
+	for k, e := range dict {
+		dict[k] = listify(e)
+	}
+	list := make([]interface{}, len(dict))
+	for k, e := range dict {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(list) || strconv.Itoa(i) != k {
+			return dict
+		}
+		list[i] = e
+	}
+	return list
+}
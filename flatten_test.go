@@ -0,0 +1,177 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlattenFilter(t *testing.T) {
+	q01 := func() map[string]interface{} {
+		return map[string]interface{}{
+			"message": "test",
+			"http": map[string]interface{}{
+				"request": map[string]interface{}{
+					"method": "GET",
+				},
+				"status": 200,
+			},
+			"tags":  []interface{}{"a", map[string]interface{}{"b": 1}},
+			"empty": map[string]interface{}{},
+		}
+	}
+
+	c01 := q01()
+	f01 := &FlattenFilter{}
+	f01.Printd(c01)
+	x01 := map[string]interface{}{
+		"message":             "test",
+		"http.request.method": "GET",
+		"http.status":         200,
+		"tags.0":              "a",
+		"tags.1.b":            1,
+		"empty":               map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(c01, x01) {
+		t.Errorf("t01: invalid result: %v", c01)
+	}
+
+	c02 := q01()
+	f02 := &FlattenFilter{
+		Style:    FlattenBracketed,
+		MaxDepth: 1,
+	}
+	f02.Printd(c02)
+	x02 := map[string]interface{}{
+		"message":       "test",
+		"http[request]": map[string]interface{}{"method": "GET"},
+		"http[status]":  200,
+		"tags[0]":       "a",
+		"tags[1]":       map[string]interface{}{"b": 1},
+		"empty":         map[string]interface{}{},
+	}
+	if !reflect.DeepEqual(c02, x02) {
+		t.Errorf("t02: invalid result: %v", c02)
+	}
+
+	c03 := map[string]interface{}{
+		"user": &marshalTestUser{id: 1, name: "bob"},
+	}
+	f03 := &FlattenFilter{
+		Separator: "_",
+	}
+	f03.Printd(c03)
+	x03 := map[string]interface{}{
+		"user_id":   1,
+		"user_name": "bob",
+	}
+	if !reflect.DeepEqual(c03, x03) {
+		t.Errorf("t03: invalid result: %v", c03)
+	}
+}
+
+func TestUnflattenFilter(t *testing.T) {
+	c01 := map[string]interface{}{
+		"message":             "test",
+		"http.request.method": "GET",
+		"http.status":         200,
+		"tags.0":              "a",
+		"tags.1.b":            1,
+	}
+	f01 := &UnflattenFilter{}
+	f01.Printd(c01)
+	x01 := map[string]interface{}{
+		"message": "test",
+		"http": map[string]interface{}{
+			"request": map[string]interface{}{
+				"method": "GET",
+			},
+			"status": 200,
+		},
+		"tags": []interface{}{"a", map[string]interface{}{"b": 1}},
+	}
+	if !reflect.DeepEqual(c01, x01) {
+		t.Errorf("t01: invalid result: %v", c01)
+	}
+
+	c02 := map[string]interface{}{
+		"a":          1,
+		"a.b":        2,
+		"x[y][z]":    3,
+		"x[w]":       4,
+		"broken[":    5,
+		"list[1]":    6,
+		"list[2]":    7,
+		"deep[1][2]": 8,
+	}
+	f02 := &UnflattenFilter{
+		Style:    FlattenBracketed,
+		MaxDepth: 1,
+	}
+	f02.Printd(c02)
+	x02 := map[string]interface{}{
+		"a":       1,
+		"a.b":     2,
+		"x":       map[string]interface{}{"y[z]": 3, "w": 4},
+		"broken[": 5,
+		"list":    map[string]interface{}{"1": 6, "2": 7},
+		"deep":    map[string]interface{}{"1[2]": 8},
+	}
+	if !reflect.DeepEqual(c02, x02) {
+		t.Errorf("t02: invalid result: %v", c02)
+	}
+
+	c03 := map[string]interface{}{
+		"a":   1,
+		"a.b": 2,
+	}
+	f03 := &UnflattenFilter{}
+	f03.Printd(c03)
+	if len(c03) != 2 || c03["a"] != 1 || c03["a.b"] != 2 {
+		t.Errorf("t03: conflicting key was modified: %v", c03)
+	}
+
+	n04 := map[string]interface{}{"request": map[string]interface{}{"method": "GET"}}
+	c04 := map[string]interface{}{
+		"http":              n04,
+		"http.request.path": "/",
+		"http.status":       200,
+	}
+	f04 := &UnflattenFilter{}
+	f04.Printd(c04)
+	x04 := map[string]interface{}{
+		"http": map[string]interface{}{
+			"request": map[string]interface{}{"method": "GET", "path": "/"},
+			"status":  200,
+		},
+	}
+	if !reflect.DeepEqual(c04, x04) {
+		t.Errorf("t04: invalid result: %v", c04)
+	}
+	if len(n04) != 1 || len(n04["request"].(map[string]interface{})) != 1 {
+		t.Errorf("t04: existing dictionaries should not be modified: %v", n04)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	// HostnameKey is the key for the host name.
+	// Type: string
+	HostnameKey = "hostname"
+	// PidKey is the key for the process ID.
+	// Type: int
+	PidKey = "pid"
+	// ExecutableKey is the key for the path of the running executable.
+	// Type: string
+	ExecutableKey = "executable"
+	// GoVersionKey is the key for the Go runtime version.
+	// Type: string
+	GoVersionKey = "go_version"
+	// ModuleVersionKey is the key for the version of the main module.
+	// Type: string
+	ModuleVersionKey = "module_version"
+	// VcsRevisionKey is the key for the VCS revision the executable was built from.
+	// Type: string
+	VcsRevisionKey = "vcs_revision"
+	// ContainerIdKey is the key for the ID of the container the process is running in.
+	// Type: string
+	ContainerIdKey = "container_id"
+	// cgroupPath is where the container ID is looked up.
+	cgroupPath = "/proc/self/cgroup"
+)
+
+var (
+	containerIdPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+)
+
+// HostInfoFilter adds information about the host and the running process
+// to each dictionary.
+// The information is gathered once, when the first dictionary is processed.
+// Like with MergeFilter, existing values are not replaced.
+//
+// Each field must be enabled separately. Values that cannot be determined
+// are omitted.
+type HostInfoFilter struct {
+	// Hostname adds HostnameKey.
+	Hostname bool
+	// Pid adds PidKey.
+	Pid bool
+	// Executable adds ExecutableKey.
+	Executable bool
+	// GoVersion adds GoVersionKey.
+	GoVersion bool
+	// ModuleVersion adds ModuleVersionKey, if the executable was built
+	// with module support.
+	ModuleVersion bool
+	// VcsRevision adds VcsRevisionKey, if the executable was built with
+	// module support and VCS stamping.
+	VcsRevision bool
+	// ContainerId adds ContainerIdKey, parsed from /proc/self/cgroup.
+	ContainerId bool
+
+	once  sync.Once
+	merge MergeFilter
+}
+
+func (filter *HostInfoFilter) Printd(kv map[string]interface{}) {
+	filter.once.Do(filter.gather)
+	filter.merge.Printd(kv)
+}
+
+func (filter *HostInfoFilter) gather() {
+	dict := make(map[string]interface{})
+	if filter.Hostname {
+		if hostname, err := os.Hostname(); err == nil {
+			dict[HostnameKey] = hostname
+		}
+	}
+	if filter.Pid {
+		dict[PidKey] = os.Getpid()
+	}
+	if filter.Executable {
+		if executable, err := os.Executable(); err == nil {
+			dict[ExecutableKey] = executable
+		}
+	}
+	if filter.GoVersion {
+		dict[GoVersionKey] = runtime.Version()
+	}
+	if filter.ModuleVersion || filter.VcsRevision {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			filter.addBuildInfo(dict, info)
+		}
+	}
+	if filter.ContainerId {
+		if file, err := os.Open(cgroupPath); err == nil {
+			if id := parseContainerId(file); id != "" {
+				dict[ContainerIdKey] = id
+			}
+			file.Close()
+		}
+	}
+	filter.merge.Dict = dict
+}
+
+// addBuildInfo adds the enabled fields from the build information.
+func (filter *HostInfoFilter) addBuildInfo(dict map[string]interface{}, info *debug.BuildInfo) {
+	if filter.ModuleVersion && info.Main.Version != "" {
+		dict[ModuleVersionKey] = info.Main.Version
+	}
+	if filter.VcsRevision {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				dict[VcsRevisionKey] = setting.Value
+			}
+		}
+	}
+}
+
+// parseContainerId looks for a container ID in a cgroup file.
+// Docker, containerd and CRI-O all use a 64 digit hex ID somewhere
+// in the cgroup path.
+func parseContainerId(cgroup io.Reader) string {
+	scanner := bufio.NewScanner(cgroup)
+	for scanner.Scan() {
+		if id := containerIdPattern.FindString(scanner.Text()); id != "" {
+			return id
+		}
+	}
+	return ""
+}
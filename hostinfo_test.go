@@ -0,0 +1,108 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestHostInfoFilter(t *testing.T) {
+	c01 := map[string]interface{}{}
+	f01 := &HostInfoFilter{}
+	f01.Printd(c01)
+	if len(c01) != 0 {
+		t.Errorf("t01: dict didn't stay empty")
+	}
+
+	c02 := map[string]interface{}{}
+	f02 := &HostInfoFilter{
+		Pid:       true,
+		GoVersion: true,
+	}
+	f02.Printd(c02)
+	if len(c02) != 2 || c02[PidKey] != os.Getpid() || c02[GoVersionKey] != runtime.Version() {
+		t.Errorf("t02: invalid process information: %v", c02)
+	}
+
+	c03 := map[string]interface{}{
+		PidKey: "test03",
+	}
+	f03 := &HostInfoFilter{
+		Pid: true,
+	}
+	f03.Printd(c03)
+	if c03[PidKey] != "test03" {
+		t.Errorf("t03: value was replaced")
+	}
+
+	hostname, _ := os.Hostname()
+	c04 := map[string]interface{}{}
+	f04 := &HostInfoFilter{
+		Hostname: true,
+	}
+	f04.Printd(c04)
+	if c04[HostnameKey] != hostname {
+		t.Errorf("t04: invalid host name: %v", c04)
+	}
+
+	i05 := &debug.BuildInfo{
+		Main: debug.Module{
+			Version: "v1.2.3",
+		},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123abc"},
+		},
+	}
+	c05a := map[string]interface{}{}
+	(&HostInfoFilter{ModuleVersion: true}).addBuildInfo(c05a, i05)
+	c05b := map[string]interface{}{}
+	(&HostInfoFilter{VcsRevision: true}).addBuildInfo(c05b, i05)
+	if len(c05a) != 1 || c05a[ModuleVersionKey] != "v1.2.3" || len(c05b) != 1 || c05b[VcsRevisionKey] != "0123abc" {
+		t.Errorf("t05: build information should be enabled separately: %v %v", c05a, c05b)
+	}
+}
+
+func TestParseContainerId(t *testing.T) {
+	id := "0b5a8a3e64a5b3cf4f2a7b0a6ef0e6d84f8b4ac0ac1d42fa9dc64b23e8b7a9f1"
+
+	q01 := "12:pids:/docker/" + id + "\n11:memory:/docker/" + id + "\n"
+	if r01 := parseContainerId(strings.NewReader(q01)); r01 != id {
+		t.Errorf("t01: invalid container id: %s", r01)
+	}
+
+	q02 := "0::/system.slice/cri-containerd-" + id + ".scope\n"
+	if r02 := parseContainerId(strings.NewReader(q02)); r02 != id {
+		t.Errorf("t02: invalid container id: %s", r02)
+	}
+
+	q03 := "0::/user.slice/user-1000.slice/session-2.scope\n"
+	if r03 := parseContainerId(strings.NewReader(q03)); r03 != "" {
+		t.Errorf("t03: unexpected container id: %s", r03)
+	}
+}
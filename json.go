@@ -25,6 +25,7 @@
 package kvl
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 )
@@ -34,12 +35,44 @@ const (
 )
 
 // JsonFormatter formats each log line into JSON and sends it to a Sink.
-type JsonFormatter struct{}
+//
+// Values are encoded with the embedded JsonEncoder, which determines
+// key order, escaping and number formatting.
+// If a value cannot be encoded, an error object is logged instead.
+//
+// If Indent is set, each record is pretty-printed over multiple lines.
+// This is intended for development and not suitable for line-based
+// log processing.
+type JsonFormatter struct {
+	JsonEncoder
+	// Indent is the indentation string for pretty-printing, for example "  ".
+	Indent string
+}
 
 func (formatter *JsonFormatter) Formatd(dict map[string]interface{}, sink io.Writer) {
-	encoder := json.NewEncoder(sink)
-	err := encoder.Encode(dict)
-	if err != nil {
-		sink.Write([]byte(jsonEncodeError))
+	state := newJsonState()
+	err := formatter.encodeDict(state, dict, 0)
+	formatter.write(state, err, sink)
+}
+
+func (formatter *JsonFormatter) Formatr(record *Record, sink io.Writer) {
+	state := newJsonState()
+	err := formatter.encodeRecord(state, record)
+	formatter.write(state, err, sink)
+}
+
+func (formatter *JsonFormatter) write(state *jsonState, err error, sink io.Writer) {
+	switch {
+	case err != nil:
+		io.WriteString(sink, jsonEncodeError)
+	case formatter.Indent != "":
+		buffer := &bytes.Buffer{}
+		json.Indent(buffer, state.buf, "", formatter.Indent)
+		buffer.WriteByte('\n')
+		sink.Write(buffer.Bytes())
+	default:
+		state.buf = append(state.buf, '\n')
+		sink.Write(state.buf)
 	}
+	state.release()
 }
@@ -27,6 +27,7 @@ package kvl
 import (
 	"bytes"
 	"testing"
+	"time"
 )
 
 func jsonTest(t *testing.T, testno string, query map[string]interface{}, expected ...[]byte) {
@@ -66,3 +67,53 @@ func TestJsonFormatter(t *testing.T) {
 	x03b := []byte("{\"message\":\"test 03\",\"value\":1234567}\n")
 	jsonTest(t, "t03", q03, x03a, x03b)
 }
+
+func TestJsonFormatterLayout(t *testing.T) {
+	now := time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC)
+
+	b01 := &bytes.Buffer{}
+	f01 := &JsonFormatter{
+		JsonEncoder: JsonEncoder{
+			LeadingKeys: StdLeadingKeys,
+		},
+	}
+	f01.Formatd(map[string]interface{}{
+		"message": "test01",
+		"count":   1,
+		"time":    now,
+		"nested":  map[string]interface{}{"time": 1, "a": 2},
+	}, b01)
+	x01 := "{\"time\":\"2018-01-31T08:59:02Z\",\"message\":\"test01\",\"count\":1,\"nested\":{\"a\":2,\"time\":1}}\n"
+	if b01.String() != x01 {
+		t.Errorf("t01: no match. expected: '%s' got: '%s'", x01, b01)
+	}
+
+	b02 := &bytes.Buffer{}
+	f02 := &JsonFormatter{
+		JsonEncoder: JsonEncoder{
+			KeyOrder:    KeyOrderInsertion,
+			LeadingKeys: StdLeadingKeys,
+			TimeFormat:  ConsoleTimeFormat,
+		},
+	}
+	r02 := newRecord([]Field{String("message", "test02"), Int("z", 1), Lvl(LevelInfo), Int("a", 2), Time("time", now)})
+	f02.Formatr(r02, b02)
+	r02.release()
+	x02 := "{\"time\":\"[2018-01-31 08:59:02]\",\"level\":\"info\",\"message\":\"test02\",\"z\":1,\"a\":2}\n"
+	if b02.String() != x02 {
+		t.Errorf("t02: no match. expected: '%s' got: '%s'", x02, b02)
+	}
+
+	b03 := &bytes.Buffer{}
+	f03 := &JsonFormatter{
+		Indent: "  ",
+	}
+	f03.Formatd(map[string]interface{}{
+		"message": "test03",
+		"count":   1,
+	}, b03)
+	x03 := "{\n  \"count\": 1,\n  \"message\": \"test03\"\n}\n"
+	if b03.String() != x03 {
+		t.Errorf("t03: no match. expected: '%s' got: '%s'", x03, b03)
+	}
+}
@@ -0,0 +1,587 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxJsonDepth is the maximum nesting depth of values, to guard
+	// against cyclic data structures.
+	maxJsonDepth = 64
+	// jsonBufferAllocation is the initial capacity of pooled buffers.
+	jsonBufferAllocation = 1024
+	// maxPooledJsonBuffer is the largest buffer that will be returned to the pool.
+	maxPooledJsonBuffer = 64 * 1024
+	hexDigits           = "0123456789abcdef"
+)
+
+var (
+	errJsonDepth = errors.New("maximum nesting depth exceeded")
+	jsonPool     = sync.Pool{
+		New: func() interface{} {
+			return &jsonState{
+				buf: make([]byte, 0, jsonBufferAllocation),
+			}
+		},
+	}
+)
+
+// KeyOrder determines the order in which JsonEncoder writes object keys.
+type KeyOrder int
+
+const (
+	// KeyOrderSorted sorts keys alphabetically. This is the default.
+	KeyOrderSorted KeyOrder = iota
+	// KeyOrderNone writes dictionaries in map iteration order and records
+	// in the order of their fields. This is the fastest option.
+	KeyOrderNone
+	// KeyOrderInsertion writes records in the order of their fields.
+	// Since dictionaries have no order, they are sorted.
+	KeyOrderInsertion
+)
+
+// JsonEncoder is a fast JSON encoder for log records.
+//
+// Common value types are encoded without reflection, in a way that is
+// compatible with encoding/json. In addition, LogMarshalers and types with
+// a registered marshaler are written as objects, errors as their message
+// and fmt.Stringers as their string representation. All other types are
+// passed to encoding/json.
+type JsonEncoder struct {
+	// KeyOrder determines the order of object keys.
+	KeyOrder KeyOrder
+	// LeadingKeys are written first, in this order, if they are present
+	// in the top-level object. KeyOrder only applies to the remaining keys.
+	LeadingKeys []string
+	// TimeFormat is the layout for time.Time values.
+	// Defaults to time.RFC3339Nano if unset, like encoding/json.
+	TimeFormat string
+	// DisableHTMLEscape turns off escaping of <, > and & in strings.
+	DisableHTMLEscape bool
+	// FloatFormat is the format passed to strconv.FormatFloat, for example
+	// 'f' or 'e'. If unset, floats are formatted like encoding/json does.
+	FloatFormat byte
+	// FloatPrecision is the precision passed to strconv.FormatFloat.
+	// Only used when FloatFormat is set; -1 selects the shortest representation.
+	FloatPrecision int
+}
+
+// jsonState holds the scratch space for encoding one record.
+type jsonState struct {
+	buf    []byte
+	keys   []string
+	fields []Field
+}
+
+func newJsonState() *jsonState {
+	state := jsonPool.Get().(*jsonState)
+	state.buf = state.buf[:0]
+	return state
+}
+
+func (state *jsonState) release() {
+	if cap(state.buf) > maxPooledJsonBuffer {
+		return
+	}
+	for i := range state.fields {
+		state.fields[i] = Field{}
+	}
+	state.fields = state.fields[:0]
+	state.keys = state.keys[:0]
+	jsonPool.Put(state)
+}
+
+// AppendDict appends the JSON encoding of a dictionary to buf.
+func (encoder *JsonEncoder) AppendDict(buf []byte, dict map[string]interface{}) ([]byte, error) {
+	state := &jsonState{buf: buf}
+	err := encoder.encodeDict(state, dict, 0)
+	return state.buf, err
+}
+
+// AppendRecord appends the JSON encoding of a record to buf.
+func (encoder *JsonEncoder) AppendRecord(buf []byte, record *Record) ([]byte, error) {
+	state := &jsonState{buf: buf}
+	err := encoder.encodeRecord(state, record)
+	return state.buf, err
+}
+
+// AppendValue appends the JSON encoding of an arbitrary value to buf.
+func (encoder *JsonEncoder) AppendValue(buf []byte, v interface{}) ([]byte, error) {
+	state := &jsonState{buf: buf}
+	err := encoder.encodeValue(state, v, 0)
+	return state.buf, err
+}
+
+func (encoder *JsonEncoder) encodeDict(state *jsonState, dict map[string]interface{}, depth int) error {
+	if depth > maxJsonDepth {
+		return errJsonDepth
+	}
+	state.buf = append(state.buf, '{')
+	first := true
+	var leading []string
+	if depth == 0 {
+		leading = encoder.LeadingKeys
+		for _, k := range leading {
+			if v, ok := dict[k]; ok {
+				if err := encoder.encodeMember(state, &first, k, v, depth); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if encoder.KeyOrder == KeyOrderNone {
+		for k, v := range dict {
+			if containsString(leading, k) {
+				continue
+			}
+			if err := encoder.encodeMember(state, &first, k, v, depth); err != nil {
+				return err
+			}
+		}
+	} else {
+		// keys are shared between nesting levels, so only use the part
+		// after what the outer levels have added
+		start := len(state.keys)
+		for k := range dict {
+			if !containsString(leading, k) {
+				state.keys = append(state.keys, k)
+			}
+		}
+		keys := state.keys[start:]
+		sortStrings(keys)
+		for _, k := range keys {
+			if err := encoder.encodeMember(state, &first, k, dict[k], depth); err != nil {
+				return err
+			}
+		}
+		state.keys = state.keys[:start]
+	}
+	state.buf = append(state.buf, '}')
+	return nil
+}
+
+func (encoder *JsonEncoder) encodeMember(state *jsonState, first *bool, k string, v interface{}, depth int) error {
+	if !*first {
+		state.buf = append(state.buf, ',')
+	}
+	*first = false
+	encoder.encodeString(state, k)
+	state.buf = append(state.buf, ':')
+	return encoder.encodeValue(state, v, depth+1)
+}
+
+func (encoder *JsonEncoder) encodeRecord(state *jsonState, record *Record) error {
+	if record.dict != nil {
+		return encoder.encodeDict(state, record.dict, 0)
+	}
+	state.fields = state.fields[:0]
+	for _, k := range encoder.LeadingKeys {
+		if field, ok := record.Lookup(k); ok {
+			state.fields = append(state.fields, field)
+		}
+	}
+	leading := len(state.fields)
+	for i, field := range record.fields {
+		if !containsString(encoder.LeadingKeys, field.Key) && !record.shadowed(i) {
+			state.fields = append(state.fields, field)
+		}
+	}
+	fields := state.fields
+	if encoder.KeyOrder == KeyOrderSorted {
+		sortFields(fields[leading:])
+	}
+	state.buf = append(state.buf, '{')
+	for i := range fields {
+		if i > 0 {
+			state.buf = append(state.buf, ',')
+		}
+		encoder.encodeString(state, fields[i].Key)
+		state.buf = append(state.buf, ':')
+		if err := encoder.encodeField(state, &fields[i], 1); err != nil {
+			return err
+		}
+	}
+	state.buf = append(state.buf, '}')
+	return nil
+}
+
+func (encoder *JsonEncoder) encodeField(state *jsonState, field *Field, depth int) error {
+	switch field.Type {
+	case FieldString:
+		encoder.encodeString(state, field.String)
+	case FieldBool:
+		state.buf = strconv.AppendBool(state.buf, field.Integer != 0)
+	case FieldInt, FieldInt64, FieldDuration:
+		state.buf = strconv.AppendInt(state.buf, field.Integer, 10)
+	case FieldUint64:
+		state.buf = strconv.AppendUint(state.buf, uint64(field.Integer), 10)
+	case FieldFloat64:
+		return encoder.encodeFloat(state, math.Float64frombits(uint64(field.Integer)), 64)
+	case FieldTime:
+		encoder.encodeTime(state, field.time())
+	case FieldError:
+		if isNilPointer(field.Interface) {
+			state.buf = append(state.buf, "null"...)
+			return nil
+		}
+		encoder.encodeString(state, field.Interface.(error).Error())
+	case FieldLevel:
+		encoder.encodeString(state, Level(field.Integer).String())
+	default:
+		return encoder.encodeValue(state, field.Interface, depth)
+	}
+	return nil
+}
+
+func (encoder *JsonEncoder) encodeValue(state *jsonState, v interface{}, depth int) error {
+	if depth > maxJsonDepth {
+		return errJsonDepth
+	}
+	switch t := v.(type) {
+	case nil:
+		state.buf = append(state.buf, "null"...)
+	case string:
+		encoder.encodeString(state, t)
+	case bool:
+		state.buf = strconv.AppendBool(state.buf, t)
+	case int:
+		state.buf = strconv.AppendInt(state.buf, int64(t), 10)
+	case int8:
+		state.buf = strconv.AppendInt(state.buf, int64(t), 10)
+	case int16:
+		state.buf = strconv.AppendInt(state.buf, int64(t), 10)
+	case int32:
+		state.buf = strconv.AppendInt(state.buf, int64(t), 10)
+	case int64:
+		state.buf = strconv.AppendInt(state.buf, t, 10)
+	case uint:
+		state.buf = strconv.AppendUint(state.buf, uint64(t), 10)
+	case uint8:
+		state.buf = strconv.AppendUint(state.buf, uint64(t), 10)
+	case uint16:
+		state.buf = strconv.AppendUint(state.buf, uint64(t), 10)
+	case uint32:
+		state.buf = strconv.AppendUint(state.buf, uint64(t), 10)
+	case uint64:
+		state.buf = strconv.AppendUint(state.buf, t, 10)
+	case float32:
+		return encoder.encodeFloat(state, float64(t), 32)
+	case float64:
+		return encoder.encodeFloat(state, t, 64)
+	case time.Time:
+		encoder.encodeTime(state, t)
+	case time.Duration:
+		// encoding/json compatible
+		state.buf = strconv.AppendInt(state.buf, int64(t), 10)
+	case json.Number:
+		// must come before fmt.Stringer, numbers are not quoted
+		return encoder.encodeNumber(state, t)
+	case *LazyValue:
+		return encoder.encodeValue(state, Resolve(t), depth+1)
+	case map[string]interface{}:
+		if t == nil {
+			state.buf = append(state.buf, "null"...)
+			return nil
+		}
+		return encoder.encodeDict(state, t, depth)
+	case []interface{}:
+		if t == nil {
+			state.buf = append(state.buf, "null"...)
+			return nil
+		}
+		state.buf = append(state.buf, '[')
+		for i, e := range t {
+			if i > 0 {
+				state.buf = append(state.buf, ',')
+			}
+			if err := encoder.encodeValue(state, e, depth+1); err != nil {
+				return err
+			}
+		}
+		state.buf = append(state.buf, ']')
+	case []string:
+		if t == nil {
+			state.buf = append(state.buf, "null"...)
+			return nil
+		}
+		state.buf = append(state.buf, '[')
+		for i, e := range t {
+			if i > 0 {
+				state.buf = append(state.buf, ',')
+			}
+			encoder.encodeString(state, e)
+		}
+		state.buf = append(state.buf, ']')
+	default:
+		return encoder.encodeOther(state, v, depth)
+	}
+	return nil
+}
+
+// encodeOther handles LogMarshalers and all types that are identified by
+// the interfaces they implement.
+func (encoder *JsonEncoder) encodeOther(state *jsonState, v interface{}, depth int) error {
+	if hasMarshaler(v) {
+		var fields []Field
+		marshalLog(v, func(field Field) {
+			fields = append(fields, field)
+		})
+		return encoder.encodeFields(state, fields, depth)
+	}
+	if isNilPointer(v) {
+		// the methods below may not accept nil receivers
+		state.buf = append(state.buf, "null"...)
+		return nil
+	}
+	switch t := v.(type) {
+	case json.Marshaler:
+		return encoder.encodeReflect(state, v)
+	case encoding.TextMarshaler:
+		text, err := t.MarshalText()
+		if err != nil {
+			return err
+		}
+		encoder.encodeString(state, string(text))
+	case error:
+		encoder.encodeString(state, t.Error())
+	case fmt.Stringer:
+		encoder.encodeString(state, t.String())
+	default:
+		return encoder.encodeReflect(state, v)
+	}
+	return nil
+}
+
+// encodeFields writes the fields produced by a LogMarshaler as an object.
+func (encoder *JsonEncoder) encodeFields(state *jsonState, fields []Field, depth int) error {
+	if encoder.KeyOrder == KeyOrderSorted {
+		sortFields(fields)
+	}
+	state.buf = append(state.buf, '{')
+	for i := range fields {
+		if i > 0 {
+			state.buf = append(state.buf, ',')
+		}
+		encoder.encodeString(state, fields[i].Key)
+		state.buf = append(state.buf, ':')
+		if err := encoder.encodeField(state, &fields[i], depth+1); err != nil {
+			return err
+		}
+	}
+	state.buf = append(state.buf, '}')
+	return nil
+}
+
+// encodeReflect falls back to encoding/json.
+func (encoder *JsonEncoder) encodeReflect(state *jsonState, v interface{}) error {
+	if !encoder.DisableHTMLEscape {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		state.buf = append(state.buf, data...)
+		return nil
+	}
+	buffer := &bytes.Buffer{}
+	jencoder := json.NewEncoder(buffer)
+	jencoder.SetEscapeHTML(false)
+	if err := jencoder.Encode(v); err != nil {
+		return err
+	}
+	// strip the newline added by Encode
+	state.buf = append(state.buf, bytes.TrimRight(buffer.Bytes(), "\n")...)
+	return nil
+}
+
+func (encoder *JsonEncoder) encodeTime(state *jsonState, t time.Time) {
+	state.buf = append(state.buf, '"')
+	state.buf = t.AppendFormat(state.buf, stringOrDefault(encoder.TimeFormat, time.RFC3339Nano))
+	state.buf = append(state.buf, '"')
+}
+
+// encodeNumber writes a json.Number without quotes, like encoding/json.
+func (encoder *JsonEncoder) encodeNumber(state *jsonState, n json.Number) error {
+	if n == "" {
+		state.buf = append(state.buf, '0')
+		return nil
+	}
+	if !isJsonNumber(string(n)) {
+		return fmt.Errorf("invalid number literal: %q", string(n))
+	}
+	state.buf = append(state.buf, n...)
+	return nil
+}
+
+// isJsonNumber checks that s is a valid JSON number literal.
+func isJsonNumber(s string) bool {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	default:
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		if i >= len(s) || s[i] < '0' || s[i] > '9' {
+			return false
+		}
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i >= len(s) || s[i] < '0' || s[i] > '9' {
+			return false
+		}
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+	}
+	return i == len(s)
+}
+
+func (encoder *JsonEncoder) encodeFloat(state *jsonState, f float64, bits int) error {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("unsupported float value: %v", f)
+	}
+	if encoder.FloatFormat != 0 {
+		state.buf = strconv.AppendFloat(state.buf, f, encoder.FloatFormat, encoder.FloatPrecision, bits)
+		return nil
+	}
+	// same algorithm as encoding/json
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	start := len(state.buf)
+	state.buf = strconv.AppendFloat(state.buf, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(state.buf) - start
+		if n >= 4 && state.buf[len(state.buf)-4] == 'e' && state.buf[len(state.buf)-3] == '-' && state.buf[len(state.buf)-2] == '0' {
+			state.buf[len(state.buf)-2] = state.buf[len(state.buf)-1]
+			state.buf = state.buf[:len(state.buf)-1]
+		}
+	}
+	return nil
+}
+
+// encodeString writes a quoted string, escaped like encoding/json does.
+func (encoder *JsonEncoder) encodeString(state *jsonState, s string) {
+	buf := append(state.buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && (encoder.DisableHTMLEscape || (b != '<' && b != '>' && b != '&')) {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON, but not valid JavaScript
+		if c == '\u2028' || c == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[c&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	state.buf = append(buf, '"')
+}
+
+// sortStrings sorts keys with an allocation-free insertion sort, which is
+// suitable for the small number of keys in a typical log record.
+func sortStrings(keys []string) {
+	if len(keys) > 32 {
+		sort.Strings(keys)
+		return
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+}
+
+// sortFields sorts fields by key, like sortStrings.
+func sortFields(fields []Field) {
+	for i := 1; i < len(fields); i++ {
+		for j := i; j > 0 && fields[j].Key < fields[j-1].Key; j-- {
+			fields[j], fields[j-1] = fields[j-1], fields[j]
+		}
+	}
+}
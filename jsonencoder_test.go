@@ -0,0 +1,300 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net"
+	"testing"
+	"time"
+)
+
+type stringerTest struct{}
+
+func (s stringerTest) String() string {
+	return "stringer"
+}
+
+type errorTest struct {
+	message string
+}
+
+func (e *errorTest) Error() string {
+	return e.message
+}
+
+type stringerPtrTest struct {
+	text string
+}
+
+func (s *stringerPtrTest) String() string {
+	return s.text
+}
+
+func TestJsonEncoderCompatibility(t *testing.T) {
+	values := []interface{}{
+		nil,
+		"plain",
+		"quote\" backslash\\ newline\n tab\t ctrl\x01 html<>& unicode äöü   invalid\xff",
+		true,
+		false,
+		0,
+		-12345,
+		int8(-8),
+		int16(16),
+		int32(-32),
+		int64(math.MinInt64),
+		uint(1),
+		uint8(8),
+		uint16(16),
+		uint32(32),
+		uint64(math.MaxUint64),
+		0.0,
+		1.5,
+		-1e-7,
+		1e21,
+		123456789.125,
+		float32(0.1),
+		float32(1e-7),
+		time.Date(2018, 1, 31, 8, 59, 2, 123000000, time.FixedZone("test", -7200)),
+		time.Second,
+		map[string]interface{}{"b": 1, "a": []interface{}{"x", 2, nil}},
+		[]interface{}{},
+		[]string{"a", "b"},
+		net.IPv4(127, 0, 0, 1),
+		struct {
+			A int `json:"a"`
+		}{1},
+		LevelWarn,
+		json.Number("42"),
+		json.Number("-1.5e+3"),
+		json.Number(""),
+		(*errorTest)(nil),
+		(*stringerPtrTest)(nil),
+	}
+	encoder := &JsonEncoder{}
+	for i, v := range values {
+		expected, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("t%02d: cannot marshal test value: %v", i+1, err)
+		}
+		result, err := encoder.AppendValue(nil, v)
+		if err != nil || !bytes.Equal(result, expected) {
+			t.Errorf("t%02d: no match. expected: '%s' got: '%s' (%v)", i+1, expected, result, err)
+		}
+	}
+}
+
+func TestJsonEncoder(t *testing.T) {
+	e01 := &JsonEncoder{}
+	r01, _ := e01.AppendValue(nil, errors.New("test01"))
+	if string(r01) != `"test01"` {
+		t.Errorf("t01: error not encoded as message: %s", r01)
+	}
+
+	r02, _ := e01.AppendValue(nil, stringerTest{})
+	if string(r02) != `"stringer"` {
+		t.Errorf("t02: stringer not encoded as string: %s", r02)
+	}
+
+	if _, err := e01.AppendValue(nil, math.NaN()); err == nil {
+		t.Errorf("t03: NaN was encoded")
+	}
+
+	c04 := map[string]interface{}{}
+	c04["self"] = c04
+	if _, err := e01.AppendDict(nil, c04); err == nil {
+		t.Errorf("t04: cycle was encoded")
+	}
+
+	e05 := &JsonEncoder{
+		DisableHTMLEscape: true,
+	}
+	r05, _ := e05.AppendValue(nil, []interface{}{"<&>", struct{ A string }{"<&>"}})
+	if string(r05) != `["<&>",{"A":"<&>"}]` {
+		t.Errorf("t05: HTML was escaped: %s", r05)
+	}
+
+	e06 := &JsonEncoder{
+		FloatFormat:    'f',
+		FloatPrecision: 2,
+	}
+	r06, _ := e06.AppendValue(nil, 1.0/3)
+	if string(r06) != `0.33` {
+		t.Errorf("t06: invalid float format: %s", r06)
+	}
+
+	e07 := &JsonEncoder{
+		KeyOrder: KeyOrderNone,
+	}
+	q07 := newRecord([]Field{String("z", "first"), Int("a", 2)})
+	r07, _ := e07.AppendRecord(nil, q07)
+	if string(r07) != `{"z":"first","a":2}` {
+		t.Errorf("t07: field order not preserved: %s", r07)
+	}
+	q07.release()
+
+	now := time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC)
+	q08 := newRecord([]Field{
+		String("message", "test08"),
+		Int("int", -1),
+		Uint64("uint", 1<<63),
+		Float64("float", 0.5),
+		Bool("bool", true),
+		Duration("duration", time.Millisecond),
+		Time("time", now),
+		Err(errors.New("failed")),
+		Lvl(LevelInfo),
+		Any("any", []interface{}{1}),
+	})
+	r08, _ := e01.AppendRecord(nil, q08)
+	x08, _ := json.Marshal(q08.Dict())
+	// errors are encoded differently
+	x08 = bytes.Replace(x08, []byte(`"error":{}`), []byte(`"error":"failed"`), 1)
+	if !bytes.Equal(r08, x08) {
+		t.Errorf("t08: no match. expected: '%s' got: '%s'", x08, r08)
+	}
+	q08.release()
+
+	var e09 error = (*errorTest)(nil)
+	q09 := newRecord([]Field{Err(e09)})
+	r09, err := e01.AppendRecord(nil, q09)
+	if err != nil || string(r09) != `{"error":null}` {
+		t.Errorf("t09: nil error pointer not encoded as null: %s %v", r09, err)
+	}
+	q09.release()
+
+	var c10 map[string]interface{}
+	d10 := json.NewDecoder(bytes.NewBufferString(`{"count":42,"ratio":0.5,"big":12345678901234567890}`))
+	d10.UseNumber()
+	if err := d10.Decode(&c10); err != nil {
+		t.Fatalf("t10: cannot decode test value: %v", err)
+	}
+	r10, err := e01.AppendDict(nil, c10)
+	if err != nil || string(r10) != `{"big":12345678901234567890,"count":42,"ratio":0.5}` {
+		t.Errorf("t10: numbers did not survive a round trip: %s %v", r10, err)
+	}
+
+	if _, err := e01.AppendValue(nil, json.Number("0x10")); err == nil {
+		t.Errorf("t11: invalid number was encoded")
+	}
+}
+
+func TestJsonFormatterRecord(t *testing.T) {
+	b01 := &bytes.Buffer{}
+	l01 := &StdLogger{
+		Logger: &Logger{
+			Formatter: &JsonFormatter{},
+			Sink:      b01,
+		},
+	}
+	l01.Printr(String("message", "test01"), Int("value", 1234567))
+	if b01.String() != "{\"message\":\"test01\",\"value\":1234567}\n" {
+		t.Errorf("t01: invalid output: %s", b01)
+	}
+
+	b02 := &bytes.Buffer{}
+	f02 := &JsonFormatter{}
+	f02.Formatd(map[string]interface{}{"bad": math.Inf(1)}, b02)
+	if b02.String() != jsonEncodeError {
+		t.Errorf("t02: invalid output: %s", b02)
+	}
+
+	l03 := &StdLogger{
+		Logger: &Logger{
+			Formatter: &JsonFormatter{},
+			Sink:      ioutil.Discard,
+		},
+	}
+	a03 := testing.AllocsPerRun(100, func() {
+		l03.Printr(String("message", "test03"), Int("value", 1234567), Duration("elapsed", time.Second))
+	})
+	if a03 > 1 && !raceEnabled {
+		t.Errorf("t03: record formatting allocated %v times", a03)
+	}
+}
+
+var benchmarkDict = map[string]interface{}{
+	"message": "benchmark message with some \"quotes\"",
+	"time":    time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC),
+	"count":   99,
+	"ratio":   0.75,
+	"ok":      true,
+	"tags":    []interface{}{"a", "b", "c"},
+	"nested":  map[string]interface{}{"key": "value", "n": 1},
+}
+
+// BenchmarkJsonReflect measures the previous implementation based on encoding/json.
+func BenchmarkJsonReflect(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		encoder := json.NewEncoder(ioutil.Discard)
+		encoder.Encode(benchmarkDict)
+	}
+}
+
+func BenchmarkJsonFormatter(b *testing.B) {
+	formatter := &JsonFormatter{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		formatter.Formatd(benchmarkDict, ioutil.Discard)
+	}
+}
+
+func BenchmarkJsonFormatterUnsorted(b *testing.B) {
+	formatter := &JsonFormatter{
+		JsonEncoder: JsonEncoder{
+			KeyOrder: KeyOrderNone,
+		},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		formatter.Formatd(benchmarkDict, ioutil.Discard)
+	}
+}
+
+func BenchmarkJsonFormatterRecord(b *testing.B) {
+	logger := &StdLogger{
+		Logger: &Logger{
+			Formatter: &JsonFormatter{},
+			Sink:      ioutil.Discard,
+		},
+	}
+	now := time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Printr(
+			String("message", "benchmark message with some \"quotes\""),
+			Time("time", now),
+			Int("count", 99),
+			Float64("ratio", 0.75),
+			Bool("ok", true),
+		)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvltest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onitake/kvl"
+)
+
+const (
+	// EnvUpdate is the environment variable that sets Update,
+	// if it is not empty.
+	EnvUpdate = "KVLTEST_UPDATE"
+)
+
+var (
+	// Update makes AssertGolden write golden files instead of comparing
+	// output with them. It is set from EnvUpdate. To use a command line
+	// flag instead, bind it in TestMain:
+	//
+	//	flag.BoolVar(&kvltest.Update, "update", kvltest.Update, "update golden files")
+	Update = os.Getenv(EnvUpdate) != ""
+)
+
+// AssertGolden compares output with the contents of a golden file,
+// typically in the testdata directory.
+//
+// Run the tests with KVLTEST_UPDATE=1, or set Update, to write the output
+// to the golden file instead, and review the changes before committing them.
+func AssertGolden(t testing.TB, path string, output []byte) bool {
+	t.Helper()
+	if Update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("cannot create golden file directory: %v", err)
+		}
+		if err := ioutil.WriteFile(path, output, 0644); err != nil {
+			t.Fatalf("cannot update golden file: %v", err)
+		}
+		return true
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Errorf("cannot read golden file, run with KVLTEST_UPDATE=1 to create it: %v", err)
+		return false
+	}
+	if !bytes.Equal(expected, output) {
+		t.Errorf("output does not match %s\nexpected:\n%s\ngot:\n%s", path, expected, output)
+		return false
+	}
+	return true
+}
+
+// Format formats records with a formatter and returns the output.
+func Format(formatter kvl.Formatter, records ...map[string]interface{}) []byte {
+	buffer := &bytes.Buffer{}
+	for _, record := range records {
+		formatter.Formatd(record, buffer)
+	}
+	return buffer.Bytes()
+}
+
+// AssertGoldenFormat formats records with a formatter and compares the
+// output with a golden file, see AssertGolden.
+func AssertGoldenFormat(t testing.TB, path string, formatter kvl.Formatter, records ...map[string]interface{}) bool {
+	t.Helper()
+	return AssertGolden(t, path, Format(formatter, records...))
+}
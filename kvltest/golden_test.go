@@ -0,0 +1,61 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvltest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/onitake/kvl"
+)
+
+func TestAssertGolden(t *testing.T) {
+	records := []map[string]interface{}{
+		{kvl.StdMessageKey: "first", "count": 1},
+		{kvl.StdMessageKey: "second", "key": "value"},
+	}
+	AssertGoldenFormat(t, "testdata/console.golden", &kvl.ConsoleFormatter{PrintKeys: true, SortKeys: true}, records...)
+
+	dir, err := ioutil.TempDir("", "kvltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "new", "output.golden")
+
+	fake := &fakeT{}
+	if AssertGolden(fake, path, []byte("output\n")) || len(fake.errors) != 1 {
+		t.Errorf("t01: missing golden files should fail")
+	}
+
+	Update = true
+	AssertGolden(fake, path, []byte("output\n"))
+	Update = false
+	if !AssertGolden(fake, path, []byte("output\n")) || AssertGolden(fake, path, []byte("changed\n")) || len(fake.errors) != 2 {
+		t.Errorf("t02: updated golden file should be compared: %q", fake.errors)
+	}
+}
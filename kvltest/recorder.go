@@ -0,0 +1,274 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package kvltest provides helpers for testing code that logs with kvl.
+//
+// A Recorder captures the dictionaries logged through it, and offers
+// assertions on them:
+//
+//	recorder := &kvltest.Recorder{}
+//	logger := &kvl.StdLogger{Logger: recorder}
+//	doSomething(logger)
+//	recorder.AssertLogged(t, "message", "done", "count", 3)
+//
+// Sink routes formatted output to the test log, and AssertGolden compares
+// output with golden files.
+package kvltest
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/onitake/kvl"
+)
+
+// Recorder is a Filter that captures all dictionaries it receives.
+// If Logger is set, the dictionaries are passed on to it, for example
+// to print them with Sink.
+//
+// The zero value is ready to use, and a Recorder may be used from
+// several goroutines.
+type Recorder struct {
+	// Logger receives the dictionaries after they are recorded.
+	Logger kvl.Filter
+
+	mutex   sync.Mutex
+	records []map[string]interface{}
+}
+
+func (recorder *Recorder) Printd(kv map[string]interface{}) {
+	// copy, so later filters cannot change what was recorded
+	record := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		record[k] = kvl.Resolve(v)
+	}
+	recorder.mutex.Lock()
+	recorder.records = append(recorder.records, record)
+	recorder.mutex.Unlock()
+	if recorder.Logger != nil {
+		recorder.Logger.Printd(kv)
+	}
+}
+
+// Records returns the recorded dictionaries in the order they were logged.
+func (recorder *Recorder) Records() []map[string]interface{} {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]map[string]interface{}(nil), recorder.records...)
+}
+
+// Len returns the number of recorded dictionaries.
+func (recorder *Recorder) Len() int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return len(recorder.records)
+}
+
+// Reset discards all recorded dictionaries.
+func (recorder *Recorder) Reset() {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.records = nil
+}
+
+// Find returns the recorded dictionaries that contain all key-value pairs.
+// See Match for how values are compared.
+func (recorder *Recorder) Find(kv ...interface{}) []map[string]interface{} {
+	var found []map[string]interface{}
+	for _, record := range recorder.Records() {
+		if Match(record, kv...) {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+// AssertLogged fails the test if no recorded dictionary contains all
+// key-value pairs.
+func (recorder *Recorder) AssertLogged(t testing.TB, kv ...interface{}) bool {
+	t.Helper()
+	if len(recorder.Find(kv...)) == 0 {
+		t.Errorf("no record matches %s\n%s", describePairs(kv), recorder.dump())
+		return false
+	}
+	return true
+}
+
+// AssertNotLogged fails the test if any recorded dictionary contains all
+// key-value pairs.
+func (recorder *Recorder) AssertNotLogged(t testing.TB, kv ...interface{}) bool {
+	t.Helper()
+	if found := recorder.Find(kv...); len(found) > 0 {
+		t.Errorf("unexpected record matches %s: %s", describePairs(kv), describeRecord(found[0]))
+		return false
+	}
+	return true
+}
+
+// AssertCount fails the test unless exactly n recorded dictionaries contain
+// all key-value pairs. Without pairs, all records are counted.
+func (recorder *Recorder) AssertCount(t testing.TB, n int, kv ...interface{}) bool {
+	t.Helper()
+	if found := recorder.Find(kv...); len(found) != n {
+		t.Errorf("expected %d records matching %s, got %d\n%s", n, describePairs(kv), len(found), recorder.dump())
+		return false
+	}
+	return true
+}
+
+// AssertMaxLevel fails the test if a record with a level above max was
+// logged, for example to check that no errors occurred.
+func (recorder *Recorder) AssertMaxLevel(t testing.TB, max kvl.Level) bool {
+	t.Helper()
+	for _, record := range recorder.Records() {
+		if level, ok := kvl.LevelOf(record[kvl.LevelKey]); ok && level > max {
+			t.Errorf("unexpected record above %s: %s", max, describeRecord(record))
+			return false
+		}
+	}
+	return true
+}
+
+// AssertOrder fails the test unless records matching each list of
+// key-value pairs were logged in this order. Other records may be
+// logged in between.
+func (recorder *Recorder) AssertOrder(t testing.TB, kvs ...[]interface{}) bool {
+	t.Helper()
+	next := 0
+	for _, record := range recorder.Records() {
+		if next < len(kvs) && Match(record, kvs[next]...) {
+			next++
+		}
+	}
+	if next < len(kvs) {
+		t.Errorf("no record matches %s in order\n%s", describePairs(kvs[next]), recorder.dump())
+		return false
+	}
+	return true
+}
+
+// dump lists the recorded dictionaries for error messages.
+func (recorder *Recorder) dump() string {
+	records := recorder.Records()
+	if len(records) == 0 {
+		return "nothing was logged"
+	}
+	lines := make([]string, len(records))
+	for i, record := range records {
+		lines[i] = "\t" + describeRecord(record)
+	}
+	return "logged:\n" + strings.Join(lines, "\n")
+}
+
+// Match checks if a dictionary contains all key-value pairs.
+//
+// Values are compared with reflect.DeepEqual, with these exceptions:
+// Levels and level names are interchangeable, a *regexp.Regexp matches
+// the %v representation of the value, and Present matches any value.
+func Match(record map[string]interface{}, kv ...interface{}) bool {
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		actual, ok := record[key]
+		if !ok {
+			return false
+		}
+		var expected interface{}
+		if i+1 < len(kv) {
+			expected = kv[i+1]
+		}
+		if !matchValue(expected, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// Present matches any value in Match and the assertions, as long as the
+// key exists.
+var Present = present{}
+
+type present struct{}
+
+func (present) String() string {
+	return "<present>"
+}
+
+func matchValue(expected interface{}, actual interface{}) bool {
+	switch e := expected.(type) {
+	case present:
+		return true
+	case *regexp.Regexp:
+		return e.MatchString(fmt.Sprint(actual))
+	}
+	if reflect.DeepEqual(expected, actual) {
+		return true
+	}
+	if e, ok := kvl.LevelOf(expected); ok {
+		if a, ok := kvl.LevelOf(actual); ok {
+			_, expectedLevel := expected.(kvl.Level)
+			_, actualLevel := actual.(kvl.Level)
+			// at least one side must be a Level, so strings do not match case-insensitively
+			return (expectedLevel || actualLevel) && e == a
+		}
+	}
+	return false
+}
+
+func describePairs(kv []interface{}) string {
+	pairs := make([]string, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		var v interface{}
+		if i+1 < len(kv) {
+			v = kv[i+1]
+		}
+		pairs = append(pairs, fmt.Sprintf("%v=%s", kv[i], describeValue(v)))
+	}
+	return "{" + strings.Join(pairs, " ") + "}"
+}
+
+func describeRecord(record map[string]interface{}) string {
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + describeValue(record[k])
+	}
+	return "{" + strings.Join(pairs, " ") + "}"
+}
+
+func describeValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvltest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/onitake/kvl"
+)
+
+// fakeT records failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+	errors []string
+	logs   []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) Log(args ...interface{}) {
+	t.logs = append(t.logs, fmt.Sprint(args...))
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := &Recorder{}
+	logger := &kvl.StdLogger{Logger: recorder}
+	logger.Printkv(kvl.LevelKey, kvl.LevelInfo, kvl.StdMessageKey, "started", "port", 8080)
+	logger.Printr(kvl.Lvl(kvl.LevelDebug), kvl.String(kvl.StdMessageKey, "request"), kvl.Err(fmt.Errorf("timeout")))
+	logger.Printkv(kvl.LevelKey, "warn", kvl.StdMessageKey, "stopped", "lazy", kvl.Lazy(func() interface{} {
+		return "evaluated"
+	}))
+
+	ok := &fakeT{}
+	recorder.AssertLogged(ok, kvl.StdMessageKey, "started", "port", 8080)
+	recorder.AssertLogged(ok, kvl.LevelKey, "info")
+	recorder.AssertLogged(ok, kvl.LevelKey, kvl.LevelWarn, "lazy", "evaluated")
+	recorder.AssertLogged(ok, "error", regexp.MustCompile("time"))
+	recorder.AssertLogged(ok, "port", Present)
+	recorder.AssertNotLogged(ok, kvl.StdMessageKey, "started", "port", 8081)
+	recorder.AssertCount(ok, 3)
+	recorder.AssertCount(ok, 3, kvl.LevelKey, Present)
+	recorder.AssertCount(ok, 1, "error", Present)
+	recorder.AssertCount(ok, 0, "error", nil)
+	recorder.AssertMaxLevel(ok, kvl.LevelWarn)
+	recorder.AssertOrder(ok, []interface{}{kvl.StdMessageKey, "started"}, []interface{}{kvl.StdMessageKey, "stopped"})
+	if len(ok.errors) != 0 {
+		t.Errorf("t01: unexpected failures: %v", ok.errors)
+	}
+
+	failed := &fakeT{}
+	if recorder.AssertLogged(failed, kvl.StdMessageKey, "missing") ||
+		recorder.AssertNotLogged(failed, "port", 8080) ||
+		recorder.AssertCount(failed, 1, kvl.LevelKey, Present) ||
+		recorder.AssertMaxLevel(failed, kvl.LevelInfo) ||
+		recorder.AssertOrder(failed, []interface{}{kvl.StdMessageKey, "stopped"}, []interface{}{kvl.StdMessageKey, "started"}) ||
+		recorder.AssertLogged(failed, kvl.LevelKey, "error") {
+		t.Errorf("t02: assertions should fail")
+	}
+	if len(failed.errors) != 6 || !strings.Contains(failed.errors[0], `message="missing"`) || !strings.Contains(failed.errors[0], `port=8080`) {
+		t.Errorf("t02: invalid failures: %q", failed.errors)
+	}
+
+	recorder.Reset()
+	if recorder.Len() != 0 {
+		t.Errorf("t03: records should be discarded")
+	}
+}
+
+func TestSink(t *testing.T) {
+	fake := &fakeT{}
+	logger, recorder := NewLogger(fake)
+	logger.Print("one")
+	sink := Sink(fake)
+	sink.Write([]byte("two\nthr"))
+	sink.Write([]byte("ee\n"))
+	if strings.Join(fake.logs, "|") != "one|two|three" || recorder.Len() != 1 {
+		t.Errorf("t01: invalid log: %q", fake.logs)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvltest
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/onitake/kvl"
+)
+
+// sink writes each line to the test log.
+type sink struct {
+	t      testing.TB
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+// Sink returns an io.Writer that sends each line to t.Log, so log output
+// only appears when a test fails or with go test -v.
+// Incomplete lines are held back until they are terminated.
+// Do not use the Sink after the test has finished.
+func Sink(t testing.TB) io.Writer {
+	return &sink{t: t}
+}
+
+func (sink *sink) Write(data []byte) (int, error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.buffer.Write(data)
+	for {
+		line, err := sink.buffer.ReadBytes('\n')
+		if err != nil {
+			// put back the incomplete line
+			rest := append([]byte(nil), line...)
+			sink.buffer.Reset()
+			sink.buffer.Write(rest)
+			break
+		}
+		sink.t.Log(string(line[:len(line)-1]))
+	}
+	return len(data), nil
+}
+
+// NewLogger creates a logger that records everything in the returned
+// Recorder and prints it to the test log with a ConsoleFormatter.
+func NewLogger(t testing.TB) (*kvl.StdLogger, *Recorder) {
+	recorder := &Recorder{
+		Logger: &kvl.Logger{
+			Formatter: &kvl.ConsoleFormatter{
+				PrintKeys: true,
+				SortKeys:  true,
+			},
+			Sink: Sink(t),
+		},
+	}
+	return &kvl.StdLogger{
+		Logger: recorder,
+	}, recorder
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// LazyValue is a log value that is only computed when it is needed.
+//
+// Use it for expensive values that would be wasted if a record is dropped
+// by a level or sampling filter. Formatters evaluate lazy values when they
+// write a record; filters that need to look at the actual value can call
+// Resolve or ResolveDict.
+// The function is called at most once, even if the value is used by
+// several formatters.
+type LazyValue struct {
+	fn    func() interface{}
+	once  sync.Once
+	value interface{}
+}
+
+// Lazy wraps a function that produces a log value.
+func Lazy(fn func() interface{}) *LazyValue {
+	return &LazyValue{
+		fn: fn,
+	}
+}
+
+// Value evaluates the function, if this hasn't happened yet, and returns
+// its result.
+func (lazy *LazyValue) Value() interface{} {
+	lazy.once.Do(func() {
+		if lazy.fn != nil {
+			lazy.value = lazy.fn()
+		}
+	})
+	return lazy.value
+}
+
+// String formats the value with %v, so lazy values work with any formatter
+// that uses the fmt package.
+func (lazy *LazyValue) String() string {
+	return fmt.Sprint(lazy.Value())
+}
+
+// MarshalJSON encodes the value with encoding/json.
+func (lazy *LazyValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(lazy.Value())
+}
+
+// Resolve returns the value of v, if it is a LazyValue, or v itself
+// otherwise. A nil LazyValue resolves to nil.
+func Resolve(v interface{}) interface{} {
+	for {
+		lazy, ok := v.(*LazyValue)
+		if !ok {
+			return v
+		}
+		if lazy == nil {
+			return nil
+		}
+		v = lazy.Value()
+	}
+}
+
+// ResolveDict replaces all lazy values in a dictionary with their results.
+// Nested dictionaries are not modified.
+func ResolveDict(kv map[string]interface{}) {
+	for k, v := range kv {
+		if _, ok := v.(*LazyValue); ok {
+			kv[k] = Resolve(v)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestLazyValue(t *testing.T) {
+	calls := 0
+	lazy := func() *LazyValue {
+		return Lazy(func() interface{} {
+			calls++
+			return "expensive"
+		})
+	}
+
+	b01 := &bytes.Buffer{}
+	l01 := &StdLogger{
+		Logger: &LevelFilter{
+			Threshold: LevelInfo,
+			Logger: &BranchFilter{
+				Loggers: []Filter{
+					&Logger{
+						Formatter: &JsonFormatter{},
+						Sink:      b01,
+					},
+					&Logger{
+						Formatter: &ConsoleFormatter{
+							PrintKeys: true,
+							SortKeys:  true,
+						},
+						Sink: b01,
+					},
+				},
+			},
+		},
+	}
+	l01.Printkv("message", "test01", "level", "debug", "value", lazy())
+	if calls != 0 || b01.Len() != 0 {
+		t.Errorf("t01: dropped value was evaluated")
+	}
+	l01.Printkv("message", "test01", "level", "info", "value", lazy())
+	x01 := "{\"level\":\"info\",\"message\":\"test01\",\"value\":\"expensive\"}\ntest01 | level: info | value: expensive\n"
+	if calls != 1 || b01.String() != x01 {
+		t.Errorf("t01: invalid output or number of evaluations (%d): %s", calls, b01)
+	}
+
+	c02 := map[string]interface{}{"value": lazy(), "other": 1}
+	ResolveDict(c02)
+	if c02["value"] != "expensive" || c02["other"] != 1 {
+		t.Errorf("t02: lazy value not resolved: %v", c02)
+	}
+
+	r03, _ := json.Marshal(struct{ Value *LazyValue }{lazy()})
+	if string(r03) != `{"Value":"expensive"}` {
+		t.Errorf("t03: invalid JSON: %s", r03)
+	}
+
+	if Resolve(Lazy(func() interface{} { return Lazy(func() interface{} { return 4 }) })) != 4 {
+		t.Errorf("t04: nested lazy value not resolved")
+	}
+
+	var v05 *LazyValue
+	if r05 := Resolve(v05); r05 != nil {
+		t.Errorf("t05: nil lazy value should resolve to nil: %#v", r05)
+	}
+	r05, err := (&JsonEncoder{}).AppendDict(nil, map[string]interface{}{"value": v05})
+	if err != nil || string(r05) != `{"value":null}` {
+		t.Errorf("t05: nil lazy value not encoded as null: %s %v", r05, err)
+	}
+}
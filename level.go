@@ -0,0 +1,267 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// LevelKey is the default key for log levels.
+	// Type: Level or string
+	LevelKey = "level"
+)
+
+// Level is the severity of a log record.
+type Level int
+
+const (
+	LevelTrace Level = iota
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var (
+	levelNames = map[Level]string{
+		LevelTrace: "trace",
+		LevelDebug: "debug",
+		LevelInfo:  "info",
+		LevelWarn:  "warn",
+		LevelError: "error",
+	}
+)
+
+func (level Level) String() string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("level%d", int(level))
+}
+
+// MarshalText makes levels appear as names in JSON output.
+func (level Level) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// UnmarshalText parses a level name.
+func (level *Level) UnmarshalText(text []byte) error {
+	l, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*level = l
+	return nil
+}
+
+// ParseLevel converts a level name to a Level.
+// Names are case-insensitive, and "warning" is accepted as an alias for "warn".
+func ParseLevel(name string) (Level, error) {
+	lower := strings.ToLower(name)
+	if lower == "warning" {
+		return LevelWarn, nil
+	}
+	for level, n := range levelNames {
+		if n == lower {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("invalid log level: %s", name)
+}
+
+// LevelOf extracts the level from a value stored under LevelKey, which
+// may be a Level or a level name. Returns false if there is no valid level.
+func LevelOf(v interface{}) (Level, bool) {
+	switch l := v.(type) {
+	case Level:
+		return l, true
+	case string:
+		level, err := ParseLevel(l)
+		return level, err == nil
+	default:
+		return LevelInfo, false
+	}
+}
+
+// LevelEnabler can be implemented by filters that drop records based on
+// their level. Frontends use it to skip building records that would be
+// dropped anyway.
+type LevelEnabler interface {
+	// LevelEnabled returns false if records of this level will be dropped.
+	LevelEnabled(level Level) bool
+}
+
+// LevelFilter drops all records with a level below Threshold and sends the
+// rest to Logger.
+// The level is taken from LevelKey, which may contain a Level or a level
+// name. Records without a valid level are always passed on.
+//
+// Threshold is only the initial threshold, and must not be modified once
+// the filter is in use. Change it with SetThreshold instead, and read the
+// threshold in effect with CurrentThreshold. Sample lets a fraction of the
+// records below the threshold through for a limited time, for debugging
+// live systems.
+type LevelFilter struct {
+	// dropped and sampled are accessed atomically, and must be first
+	// for 64-bit alignment on 32-bit platforms.
+	dropped uint64
+	sampled uint64
+
+	// Threshold is the initial threshold. See SetThreshold.
+	Threshold Level
+	Logger    Filter
+	// Clock is used to expire sampling. Defaults to SystemClock.
+	Clock Clock
+
+	// mutex serializes changes to state
+	mutex sync.Mutex
+	// state holds a *levelState once the settings have been changed
+	state atomic.Value
+}
+
+// levelState holds the settings of a LevelFilter that can be changed at runtime.
+type levelState struct {
+	threshold Level
+	// sampleLevel is the lowest level that is sampled,
+	// if sampleEvery is not zero.
+	sampleLevel Level
+	sampleRate  float64
+	sampleEvery uint64
+	sampleUntil time.Time
+}
+
+// loadState returns the current settings. Until they are changed for the
+// first time, they are derived from the exported fields.
+func (filter *LevelFilter) loadState() levelState {
+	if state, ok := filter.state.Load().(*levelState); ok {
+		return *state
+	}
+	return levelState{threshold: filter.Threshold}
+}
+
+// sampling checks if records of a level below the threshold may be sampled.
+func (filter *LevelFilter) sampling(state *levelState, level Level) bool {
+	return state.sampleEvery > 0 && level >= state.sampleLevel && clockOrDefault(filter.Clock).Now().Before(state.sampleUntil)
+}
+
+// pass decides if a record with a level is passed on.
+func (filter *LevelFilter) pass(level Level) bool {
+	state := filter.loadState()
+	if level >= state.threshold {
+		return true
+	}
+	if filter.sampling(&state, level) && (atomic.AddUint64(&filter.sampled, 1)-1)%state.sampleEvery == 0 {
+		return true
+	}
+	atomic.AddUint64(&filter.dropped, 1)
+	return false
+}
+
+func (filter *LevelFilter) LevelEnabled(level Level) bool {
+	state := filter.loadState()
+	return (level >= state.threshold || filter.sampling(&state, level)) && levelEnabled(filter.Logger, level)
+}
+
+func (filter *LevelFilter) Printd(kv map[string]interface{}) {
+	if level, ok := LevelOf(kv[LevelKey]); ok && !filter.pass(level) {
+		return
+	}
+	if filter.Logger != nil {
+		filter.Logger.Printd(kv)
+	}
+}
+
+func (filter *LevelFilter) Printr(record *Record) {
+	if field, ok := record.Lookup(LevelKey); ok {
+		if level, ok := field.level(); ok && !filter.pass(level) {
+			return
+		}
+	}
+	printRecord(filter.Logger, record)
+}
+
+// CurrentThreshold returns the threshold that is in effect.
+func (filter *LevelFilter) CurrentThreshold() Level {
+	return filter.loadState().threshold
+}
+
+// SetThreshold changes the threshold while the filter is in use.
+// Threshold keeps its initial value.
+func (filter *LevelFilter) SetThreshold(level Level) {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+	state := filter.loadState()
+	state.threshold = level
+	filter.state.Store(&state)
+}
+
+// Sample lets a fraction of the records below the threshold pass for
+// a limited time, down to level. For example, Sample(LevelDebug, 0.1,
+// 5*time.Minute) passes every tenth debug record for five minutes.
+// A rate of 1 passes all records, and a rate or duration of 0 ends
+// sampling immediately.
+func (filter *LevelFilter) Sample(level Level, rate float64, duration time.Duration) {
+	filter.mutex.Lock()
+	defer filter.mutex.Unlock()
+	state := filter.loadState()
+	if rate <= 0 || duration <= 0 {
+		state.sampleEvery = 0
+	} else {
+		state.sampleLevel = level
+		state.sampleRate = math.Min(rate, 1)
+		state.sampleEvery = uint64(math.Round(1 / state.sampleRate))
+		state.sampleUntil = clockOrDefault(filter.Clock).Now().Add(duration)
+	}
+	filter.state.Store(&state)
+}
+
+// Sampling returns the current sampling settings.
+// ok is false if sampling is not active.
+func (filter *LevelFilter) Sampling() (level Level, rate float64, until time.Time, ok bool) {
+	state := filter.loadState()
+	if state.sampleEvery == 0 || !clockOrDefault(filter.Clock).Now().Before(state.sampleUntil) {
+		return LevelInfo, 0, time.Time{}, false
+	}
+	return state.sampleLevel, state.sampleRate, state.sampleUntil, true
+}
+
+// Dropped returns the number of records that were dropped so far.
+func (filter *LevelFilter) Dropped() uint64 {
+	return atomic.LoadUint64(&filter.dropped)
+}
+
+// levelEnabled asks filter whether a level is enabled, if it can tell.
+func levelEnabled(filter Filter, level Level) bool {
+	if enabler, ok := filter.(LevelEnabler); ok {
+		return enabler.LevelEnabled(level)
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	for level, name := range levelNames {
+		if r, err := ParseLevel(name); err != nil || r != level {
+			t.Errorf("t01: level %s not parsed: %v %v", name, r, err)
+		}
+	}
+	if r02, err := ParseLevel("WARNING"); err != nil || r02 != LevelWarn {
+		t.Errorf("t02: alias not accepted: %v %v", r02, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("t03: invalid level accepted")
+	}
+	r04, _ := json.Marshal(map[string]interface{}{LevelKey: LevelDebug})
+	if string(r04) != `{"level":"debug"}` {
+		t.Errorf("t04: invalid JSON: %s", r04)
+	}
+}
+
+func TestLevelFilter(t *testing.T) {
+	r01 := &recordFilter{}
+	f01 := &LevelFilter{
+		Threshold: LevelInfo,
+		Logger:    r01,
+	}
+	f01.Printd(map[string]interface{}{LevelKey: LevelDebug})
+	f01.Printd(map[string]interface{}{LevelKey: "trace"})
+	f01.Printd(map[string]interface{}{LevelKey: LevelInfo})
+	f01.Printd(map[string]interface{}{LevelKey: "error"})
+	f01.Printd(map[string]interface{}{"message": "test01"})
+	if len(r01.records) != 3 {
+		t.Errorf("t01: invalid number of records: %v", r01.records)
+	}
+	if f01.LevelEnabled(LevelDebug) || !f01.LevelEnabled(LevelWarn) {
+		t.Errorf("t01: invalid enabled levels")
+	}
+
+	f02 := &MultiFilter{
+		Logger: &LevelFilter{
+			Threshold: LevelWarn,
+			Logger: &LevelFilter{
+				Threshold: LevelError,
+			},
+		},
+	}
+	if f02.LevelEnabled(LevelWarn) || !f02.LevelEnabled(LevelError) {
+		t.Errorf("t02: levels not checked along the chain")
+	}
+}
+
+func TestLevelFilterRuntime(t *testing.T) {
+	clock := NewFakeClock(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	r01 := &recordFilter{}
+	f01 := &LevelFilter{
+		Threshold: LevelInfo,
+		Logger:    r01,
+		Clock:     clock,
+	}
+	f01.Printd(map[string]interface{}{LevelKey: LevelDebug})
+	f01.SetThreshold(LevelDebug)
+	f01.Printd(map[string]interface{}{LevelKey: LevelDebug})
+	f01.Printd(map[string]interface{}{LevelKey: LevelTrace})
+	if len(r01.records) != 1 || f01.Dropped() != 2 || f01.CurrentThreshold() != LevelDebug {
+		t.Errorf("t01: threshold not changed: %d records, %d dropped", len(r01.records), f01.Dropped())
+	}
+
+	r02 := &recordFilter{}
+	f02 := &LevelFilter{
+		Threshold: LevelInfo,
+		Logger:    r02,
+		Clock:     clock,
+	}
+	if _, _, _, ok := f02.Sampling(); ok {
+		t.Errorf("t02: sampling should be off by default")
+	}
+	f02.Sample(LevelDebug, 0.25, time.Minute)
+	if !f02.LevelEnabled(LevelDebug) || f02.LevelEnabled(LevelTrace) {
+		t.Errorf("t02: sampled levels should be enabled")
+	}
+	for i := 0; i < 8; i++ {
+		f02.Printd(map[string]interface{}{LevelKey: LevelDebug})
+	}
+	f02.Printr(newRecord([]Field{Lvl(LevelTrace)}))
+	if len(r02.records) != 2 || f02.Dropped() != 7 {
+		t.Errorf("t02: every fourth record should be sampled: %d records, %d dropped", len(r02.records), f02.Dropped())
+	}
+	if level, rate, until, ok := f02.Sampling(); !ok || level != LevelDebug || rate != 0.25 || !until.Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("t02: invalid sampling settings: %v %v %v %v", level, rate, until, ok)
+	}
+
+	clock.Advance(time.Minute)
+	f02.Printd(map[string]interface{}{LevelKey: LevelDebug})
+	if _, _, _, ok := f02.Sampling(); ok || len(r02.records) != 2 || f02.LevelEnabled(LevelDebug) {
+		t.Errorf("t03: sampling should expire")
+	}
+
+	f02.Sample(LevelTrace, 1, time.Minute)
+	f02.Sample(LevelTrace, 0, time.Minute)
+	if f02.LevelEnabled(LevelTrace) {
+		t.Errorf("t04: a rate of 0 should end sampling")
+	}
+}
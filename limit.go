@@ -0,0 +1,281 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// TruncatedKey is set by LimitFilter when a record was truncated.
+	// Type: bool
+	TruncatedKey = "truncated"
+	// truncatedMarker is appended to truncated values, followed by the
+	// amount of data that was removed.
+	truncatedMarker = "…[truncated %s]"
+)
+
+// LimitFilter enforces size limits on records, to protect collectors and
+// terminals from accidentally logged huge values.
+//
+// Truncated strings end with a marker like "…[truncated 4.9MB]", and
+// values that are removed entirely are replaced with such a marker.
+// If anything was truncated, TruncatedKey is set to true.
+//
+// Nested dictionaries and lists are copied before they are modified.
+// Lazy values are evaluated, so place this filter after any filters that
+// might drop the record.
+// All limits are disabled if set to 0.
+type LimitFilter struct {
+	// MaxStringLength is the maximum length of strings and byte slices,
+	// in bytes. Nested values are included.
+	MaxStringLength int
+	// MaxKeys is the maximum number of top-level keys, not counting
+	// TruncatedKey. The keys in StdLeadingKeys are kept first, then the
+	// rest in alphabetical order. The keys in StdLeadingKeys are never
+	// removed, so a record may exceed a MaxKeys below their number.
+	MaxKeys int
+	// MaxDepth is the maximum nesting depth of dictionaries and lists.
+	// Deeper values are replaced by a marker.
+	MaxDepth int
+	// MaxSize is the maximum size of the record when encoded as JSON,
+	// in bytes, including TruncatedKey. If it is exceeded, the largest
+	// values are truncated until the record fits. If that is not enough,
+	// for example because of long keys, the largest keys not in
+	// StdLeadingKeys are removed, and finally the values of the leading
+	// keys are replaced by a marker.
+	MaxSize int
+}
+
+func (filter *LimitFilter) Printd(kv map[string]interface{}) {
+	truncated := false
+	if filter.MaxKeys > 0 && len(kv) > filter.MaxKeys {
+		filter.limitKeys(kv)
+		truncated = true
+	}
+	for k, v := range kv {
+		if limited, ok := filter.limit(v, 1); ok {
+			kv[k] = limited
+			truncated = true
+		}
+	}
+	if filter.MaxSize > 0 && filter.limitSize(kv, truncated) {
+		truncated = true
+	}
+	if truncated {
+		kv[TruncatedKey] = true
+	}
+}
+
+func (filter *LimitFilter) limitKeys(kv map[string]interface{}) {
+	keep := 0
+	for _, k := range StdLeadingKeys {
+		if _, ok := kv[k]; ok {
+			keep++
+		}
+	}
+	for _, k := range OrderedStringKeys(kv) {
+		if containsString(StdLeadingKeys, k) {
+			continue
+		}
+		if keep < filter.MaxKeys {
+			keep++
+		} else {
+			delete(kv, k)
+		}
+	}
+}
+
+// limit applies the string length and depth limits to a value.
+// It returns the new value and true if anything was truncated.
+func (filter *LimitFilter) limit(v interface{}, depth int) (interface{}, bool) {
+	switch t := Resolve(v).(type) {
+	case string:
+		if filter.MaxStringLength > 0 && len(t) > filter.MaxStringLength {
+			return truncateString(t, filter.MaxStringLength), true
+		}
+	case []byte:
+		if filter.MaxStringLength > 0 && len(t) > filter.MaxStringLength {
+			return truncateString(string(t), filter.MaxStringLength), true
+		}
+	case map[string]interface{}:
+		if filter.MaxDepth > 0 && depth > filter.MaxDepth {
+			return fmt.Sprintf(truncatedMarker, "depth"), true
+		}
+		var dup map[string]interface{}
+		for k, e := range t {
+			if limited, ok := filter.limit(e, depth+1); ok {
+				if dup == nil {
+					dup = copyDict(t)
+				}
+				dup[k] = limited
+			}
+		}
+		if dup != nil {
+			return dup, true
+		}
+	case []interface{}:
+		if filter.MaxDepth > 0 && depth > filter.MaxDepth {
+			return fmt.Sprintf(truncatedMarker, "depth"), true
+		}
+		var dup []interface{}
+		for i, e := range t {
+			if limited, ok := filter.limit(e, depth+1); ok {
+				if dup == nil {
+					dup = append([]interface{}(nil), t...)
+				}
+				dup[i] = limited
+			}
+		}
+		if dup != nil {
+			return dup, true
+		}
+	}
+	return v, false
+}
+
+// limitSize shrinks the record until its encoded size fits into MaxSize,
+// including TruncatedKey if the record is truncated. Each field is encoded
+// once, and the total is updated as fields are shrunk or removed.
+// Returns true if anything was truncated.
+func (filter *LimitFilter) limitSize(kv map[string]interface{}, truncated bool) bool {
+	encoder := &JsonEncoder{
+		KeyOrder: KeyOrderNone,
+	}
+	keys := make([]string, 0, len(kv))
+	keySizes := make(map[string]int, len(kv))
+	valueSizes := make(map[string]int, len(kv))
+	// braces and commas
+	total := 1
+	for k, v := range kv {
+		value, err := encoder.AppendValue(nil, v)
+		if err != nil {
+			// will be replaced by the formatter anyway
+			continue
+		}
+		key, _ := encoder.AppendValue(nil, k)
+		keys = append(keys, k)
+		keySizes[k] = len(key) + 1
+		valueSizes[k] = len(value)
+		total += len(key) + 1 + len(value) + 1
+	}
+	reserve := 0
+	if _, ok := kv[TruncatedKey]; !ok {
+		reserve = len(TruncatedKey) + len(`,"":true`)
+	}
+	if total <= filter.MaxSize && (!truncated || total+reserve <= filter.MaxSize) {
+		return false
+	}
+	// the flag will be added
+	total += reserve
+
+	// truncate the largest values first
+	sort.Slice(keys, func(i, j int) bool {
+		if valueSizes[keys[i]] != valueSizes[keys[j]] {
+			return valueSizes[keys[i]] > valueSizes[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		if total <= filter.MaxSize {
+			return true
+		}
+		if k == TruncatedKey {
+			continue
+		}
+		excess := total - filter.MaxSize
+		size := valueSizes[k]
+		marker := fmt.Sprintf(truncatedMarker, formatSize(size))
+		var shrunk interface{}
+		if s, ok := kv[k].(string); ok && len(s)-excess-len(marker) > 0 {
+			shrunk = truncateString(s, len(s)-excess-len(marker))
+		} else if size > len(marker)+2 {
+			shrunk = marker
+		} else {
+			// the remaining values are even smaller
+			break
+		}
+		value, _ := encoder.AppendValue(nil, shrunk)
+		kv[k] = shrunk
+		valueSizes[k] = len(value)
+		total += len(value) - size
+	}
+
+	// if that was not enough, remove the largest keys, and shorten the
+	// leading keys only when nothing else is left
+	sort.Slice(keys, func(i, j int) bool {
+		si, sj := keySizes[keys[i]]+valueSizes[keys[i]], keySizes[keys[j]]+valueSizes[keys[j]]
+		if si != sj {
+			return si > sj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		if total <= filter.MaxSize {
+			return true
+		}
+		if k != TruncatedKey && !containsString(StdLeadingKeys, k) {
+			delete(kv, k)
+			total -= keySizes[k] + valueSizes[k] + 1
+		}
+	}
+	for _, k := range keys {
+		if total <= filter.MaxSize {
+			break
+		}
+		marker := fmt.Sprintf(truncatedMarker, formatSize(valueSizes[k]))
+		if containsString(StdLeadingKeys, k) && valueSizes[k] > len(marker)+2 {
+			kv[k] = marker
+			total -= valueSizes[k] - len(marker) - 2
+		}
+	}
+	return true
+}
+
+// truncateString cuts s to at most max bytes at a rune boundary and
+// appends the truncation marker.
+func truncateString(s string, max int) string {
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + fmt.Sprintf(truncatedMarker, formatSize(len(s)-cut))
+}
+
+// formatSize formats a number of bytes with a decimal unit prefix.
+func formatSize(n int) string {
+	switch {
+	case n >= 1000*1000*1000:
+		return fmt.Sprintf("%.1fGB", float64(n)/1e9)
+	case n >= 1000*1000:
+		return fmt.Sprintf("%.1fMB", float64(n)/1e6)
+	case n >= 1000:
+		return fmt.Sprintf("%.1fKB", float64(n)/1e3)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
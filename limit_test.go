@@ -0,0 +1,152 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLimitFilter(t *testing.T) {
+	c01 := map[string]interface{}{
+		"message": "test01",
+		"payload": strings.Repeat("x", 5000000),
+	}
+	f01 := &LimitFilter{
+		MaxStringLength: 100000,
+	}
+	f01.Printd(c01)
+	if c01["payload"] != strings.Repeat("x", 100000)+"…[truncated 4.9MB]" || c01[TruncatedKey] != true || c01["message"] != "test01" {
+		t.Errorf("t01: string not truncated")
+	}
+
+	c02 := map[string]interface{}{
+		"message": "test02",
+	}
+	f02 := &LimitFilter{
+		MaxStringLength: 10,
+		MaxKeys:         10,
+		MaxDepth:        1,
+		MaxSize:         1000,
+	}
+	f02.Printd(c02)
+	if len(c02) != 1 {
+		t.Errorf("t02: record within limits was modified: %v", c02)
+	}
+
+	c03 := map[string]interface{}{
+		"message": "test03",
+		"b":       1,
+		"a":       2,
+		"c":       3,
+	}
+	f03 := &LimitFilter{
+		MaxKeys: 2,
+	}
+	f03.Printd(c03)
+	if len(c03) != 3 || c03["message"] != "test03" || c03["a"] != 2 || c03[TruncatedKey] != true {
+		t.Errorf("t03: keys not limited: %v", c03)
+	}
+
+	nested := map[string]interface{}{
+		"inner": map[string]interface{}{"deep": []interface{}{1}},
+		"text":  "äääää",
+	}
+	c04 := map[string]interface{}{
+		"nested": nested,
+	}
+	f04 := &LimitFilter{
+		MaxStringLength: 3,
+		MaxDepth:        2,
+	}
+	f04.Printd(c04)
+	r04 := c04["nested"].(map[string]interface{})
+	if r04["text"] != "ä…[truncated 8B]" || r04["inner"].(map[string]interface{})["deep"] != "…[truncated depth]" {
+		t.Errorf("t04: nested values not limited: %v", c04)
+	}
+	if nested["text"] != "äääää" {
+		t.Errorf("t04: original value was modified")
+	}
+
+	c05 := map[string]interface{}{
+		"message": "test05",
+		"small":   strings.Repeat("s", 50),
+		"large":   strings.Repeat("l", 500),
+		"list":    []interface{}{strings.Repeat("x", 500)},
+	}
+	f05 := &LimitFilter{
+		MaxSize: 300,
+	}
+	f05.Printd(c05)
+	data, _ := (&JsonEncoder{}).AppendDict(nil, c05)
+	if len(data) > 300 || c05["small"] != strings.Repeat("s", 50) || c05["list"] != "…[truncated 504B]" {
+		t.Errorf("t05: size not limited (%d bytes): %s", len(data), data)
+	}
+
+	c06 := map[string]interface{}{
+		"message": "test06",
+	}
+	for i := 0; i < 50; i++ {
+		c06[fmt.Sprintf("a_rather_long_key_name_%02d", i)] = i
+	}
+	f06 := &LimitFilter{
+		MaxSize: 200,
+	}
+	f06.Printd(c06)
+	data, _ = (&JsonEncoder{}).AppendDict(nil, c06)
+	if len(data) > 200 || c06["message"] != "test06" || c06[TruncatedKey] != true {
+		t.Errorf("t06: size not limited (%d bytes): %s", len(data), data)
+	}
+
+	c07 := map[string]interface{}{
+		"message": strings.Repeat("m", 100),
+		"level":   strings.Repeat("l", 100),
+	}
+	f07 := &LimitFilter{
+		MaxSize: 100,
+	}
+	f07.Printd(c07)
+	data, _ = (&JsonEncoder{}).AppendDict(nil, c07)
+	if len(data) > 100 {
+		t.Errorf("t07: size not limited (%d bytes): %s", len(data), data)
+	}
+
+	c08 := map[string]interface{}{
+		"message": "test08",
+	}
+	for i := 0; i < 200; i++ {
+		c08[fmt.Sprintf("key%03d", i)] = strings.Repeat("\"<", i%7)
+	}
+	f08 := &LimitFilter{
+		MaxKeys: 150,
+		MaxSize: 500,
+	}
+	f08.Printd(c08)
+	data, _ = (&JsonEncoder{}).AppendDict(nil, c08)
+	if len(data) > 500 || c08["message"] != "test08" || c08[TruncatedKey] != true {
+		t.Errorf("t08: size not limited (%d bytes): %s", len(data), data)
+	}
+}
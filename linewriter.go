@@ -0,0 +1,189 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	errLineWriterClosed = errors.New("write to closed LineWriter")
+)
+
+// LineFormat determines how LineWriter parses lines.
+type LineFormat int
+
+const (
+	// LineText logs each line as StdMessageKey. This is the default.
+	LineText LineFormat = iota
+	// LineJson parses lines that contain a JSON object.
+	// Other lines are logged as text.
+	LineJson
+	// LineLogfmt parses lines as logfmt key=value pairs.
+	// Lines without any pairs are logged as text.
+	LineLogfmt
+)
+
+// LineWriter is an io.Writer that turns text into log records, one per line.
+// Use it to capture the output of subprocesses or of libraries that
+// write to an io.Writer.
+//
+// Partial writes are buffered until a line is complete. Trailing carriage
+// returns are removed and empty lines are skipped. Lines longer than 1MB
+// are split at a character boundary.
+//
+// Each record carries the fixed fields that were passed to NewLineWriter.
+// They take precedence over keys parsed from the line.
+type LineWriter struct {
+	// Format determines how lines are parsed.
+	Format LineFormat
+
+	filter Filter
+	fields map[string]interface{}
+	mutex  sync.Mutex
+	buffer []byte
+	closed bool
+}
+
+// NewLineWriter creates a LineWriter that sends records to filter,
+// with fixed fields given as key-value pairs, like Printkv:
+//
+//	cmd.Stderr = kvl.NewLineWriter(logger, "source", "ffmpeg", "stream", "stderr")
+//
+// Call Close when done, to log the last line if it was not terminated.
+func NewLineWriter(filter Filter, kv ...interface{}) *LineWriter {
+	return &LineWriter{
+		filter: filter,
+		fields: SliceToMap(kv),
+	}
+}
+
+func (writer *LineWriter) Write(data []byte) (int, error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return 0, errLineWriterClosed
+	}
+	writer.buffer = append(writer.buffer, data...)
+	start := 0
+	for {
+		end := bytes.IndexByte(writer.buffer[start:], '\n')
+		if end < 0 {
+			if len(writer.buffer)-start < maxLineLength {
+				break
+			}
+			end = maxLineLength
+			// do not split a multi-byte character
+			for i := 0; i < utf8.UTFMax-1 && !utf8.RuneStart(writer.buffer[start+end]); i++ {
+				end--
+			}
+		}
+		writer.emit(writer.buffer[start : start+end])
+		start += end
+		if start < len(writer.buffer) && writer.buffer[start] == '\n' {
+			start++
+		}
+	}
+	// keep the partial line at the start of the buffer
+	writer.buffer = writer.buffer[:copy(writer.buffer, writer.buffer[start:])]
+	return len(data), nil
+}
+
+// Close logs the last line, if it was not terminated.
+// Further writes fail.
+func (writer *LineWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if !writer.closed {
+		writer.emit(writer.buffer)
+		writer.buffer = nil
+		writer.closed = true
+	}
+	return nil
+}
+
+// emit parses a line and sends it on.
+func (writer *LineWriter) emit(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(bytes.TrimSpace(line)) == 0 || writer.filter == nil {
+		return
+	}
+	var kv map[string]interface{}
+	switch writer.Format {
+	case LineJson:
+		kv = decodeJsonLine(line)
+	case LineLogfmt:
+		kv = parseLogfmt(string(line))
+		if !hasLogfmtPairs(kv) {
+			kv = nil
+		}
+	}
+	if kv == nil {
+		kv = map[string]interface{}{
+			StdMessageKey: string(line),
+		}
+	}
+	for k, v := range writer.fields {
+		kv[k] = v
+	}
+	writer.filter.Printd(kv)
+}
+
+// decodeJsonLine parses a line that contains a JSON object.
+// Numbers are kept as json.Number, so they are not changed by conversion
+// to floating point. Returns nil if the line is not a single JSON object.
+func decodeJsonLine(line []byte) map[string]interface{} {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 || line[0] != '{' {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var kv map[string]interface{}
+	if decoder.Decode(&kv) != nil {
+		return nil
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		// trailing data after the object
+		return nil
+	}
+	return kv
+}
+
+// hasLogfmtPairs checks if a parsed logfmt line contains at least one
+// key=value pair, rather than just words.
+func hasLogfmtPairs(kv map[string]interface{}) bool {
+	for _, v := range kv {
+		if _, ok := v.(string); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLineWriter(t *testing.T) {
+	r01 := &recordFilter{}
+	w01 := NewLineWriter(r01, "source", "ffmpeg", "stream", "stderr")
+	fmt.Fprint(w01, "first line\r\nsecond ")
+	if len(r01.records) != 1 || r01.records[0][StdMessageKey] != "first line" || r01.records[0]["source"] != "ffmpeg" || r01.records[0]["stream"] != "stderr" {
+		t.Errorf("t01: invalid records: %v", r01.records)
+	}
+	fmt.Fprint(w01, "line\n\n  \nunterminated")
+	if len(r01.records) != 2 || r01.records[1][StdMessageKey] != "second line" {
+		t.Errorf("t02: partial writes should be joined: %v", r01.records)
+	}
+	w01.Close()
+	if len(r01.records) != 3 || r01.records[2][StdMessageKey] != "unterminated" {
+		t.Errorf("t03: the last line should be flushed on close: %v", r01.records)
+	}
+	if _, err := w01.Write([]byte("more\n")); err == nil {
+		t.Errorf("t04: writes after close should fail")
+	}
+
+	r05 := &recordFilter{}
+	w05 := NewLineWriter(r05, "source", "tool")
+	w05.Format = LineJson
+	fmt.Fprint(w05, "{\"message\": \"parsed\", \"count\": 12345678901234567890, \"source\": \"forged\"}\nnot json\n")
+	if len(r05.records) != 2 || r05.records[0][StdMessageKey] != "parsed" || r05.records[0]["count"] != json.Number("12345678901234567890") {
+		t.Errorf("t05: invalid JSON records: %v", r05.records)
+	}
+	if r05.records[0]["source"] != "tool" || r05.records[1][StdMessageKey] != "not json" {
+		t.Errorf("t05: fixed fields should take precedence: %v", r05.records)
+	}
+
+	r06 := &recordFilter{}
+	w06 := NewLineWriter(r06)
+	w06.Format = LineLogfmt
+	fmt.Fprint(w06, "level=warn msg=\"disk full\" retry\njust some words\n")
+	if len(r06.records) != 2 || r06.records[0]["msg"] != "disk full" || r06.records[0]["retry"] != true || r06.records[1][StdMessageKey] != "just some words" {
+		t.Errorf("t06: invalid logfmt records: %v", r06.records)
+	}
+
+	r07 := &recordFilter{}
+	w07 := NewLineWriter(r07)
+	w07.Write([]byte(strings.Repeat("x", maxLineLength+10)))
+	w07.Close()
+	if len(r07.records) != 2 || len(r07.records[0][StdMessageKey].(string)) != maxLineLength {
+		t.Errorf("t07: long lines should be split")
+	}
+
+	r08 := &recordFilter{}
+	w08 := NewLineWriter(r08)
+	w08.Write([]byte(strings.Repeat("x", maxLineLength-1) + "ä"))
+	w08.Close()
+	if len(r08.records) != 2 || !utf8.ValidString(r08.records[0][StdMessageKey].(string)) || r08.records[1][StdMessageKey] != "ä" {
+		t.Errorf("t08: long lines should be split between characters")
+	}
+
+	r09 := &recordFilter{}
+	w09 := NewLineWriter(r09)
+	w09.Format = LineJson
+	fmt.Fprint(w09, "{\"count\": 42, \"ratio\": 0.5} trailing\n{\"count\": 42, \"ratio\": 0.5}\n")
+	if len(r09.records) != 2 || r09.records[0][StdMessageKey] != "{\"count\": 42, \"ratio\": 0.5} trailing" {
+		t.Errorf("t09: lines with trailing data should be logged as text: %v", r09.records)
+	}
+	b09 := &bytes.Buffer{}
+	(&JsonFormatter{}).Formatd(r09.records[1], b09)
+	if b09.String() != "{\"count\":42,\"ratio\":0.5}\n" {
+		t.Errorf("t09: numbers should keep their type: %s", b09.String())
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// LogfmtFormatter formats each log line as logfmt, a sequence of key=value
+// pairs separated by spaces, and sends it to a Sink.
+//
+// Keys in LeadingKeys are written first, in this order, the remaining keys
+// are sorted alphabetically.
+// Values that contain spaces, quotes, equal signs or control characters
+// are quoted with Go-style escape sequences. Maps, slices, structs and
+// LogMarshalers are written as JSON.
+type LogfmtFormatter struct {
+	// LeadingKeys are written first, if they are present.
+	LeadingKeys []string
+	// TimeFormat is the layout for time.Time values.
+	// Defaults to time.RFC3339Nano if unset.
+	TimeFormat string
+}
+
+func (formatter *LogfmtFormatter) Formatd(dict map[string]interface{}, sink io.Writer) {
+	state := newJsonState()
+	for _, k := range formatter.LeadingKeys {
+		if v, ok := dict[k]; ok {
+			formatter.appendPair(state, k, v)
+		}
+	}
+	for k := range dict {
+		if !containsString(formatter.LeadingKeys, k) {
+			state.keys = append(state.keys, k)
+		}
+	}
+	sortStrings(state.keys)
+	for _, k := range state.keys {
+		formatter.appendPair(state, k, dict[k])
+	}
+	state.buf = append(state.buf, '\n')
+	sink.Write(state.buf)
+	state.release()
+}
+
+func (formatter *LogfmtFormatter) appendPair(state *jsonState, k string, v interface{}) {
+	if len(state.buf) > 0 {
+		state.buf = append(state.buf, ' ')
+	}
+	state.buf = append(state.buf, logfmtKey(k)...)
+	state.buf = append(state.buf, '=')
+	value := formatter.value(v)
+	if logfmtNeedsQuote(value) {
+		state.buf = strconv.AppendQuote(state.buf, value)
+	} else {
+		state.buf = append(state.buf, value...)
+	}
+}
+
+// value converts a value to its unquoted logfmt representation.
+func (formatter *LogfmtFormatter) value(v interface{}) string {
+	v = Resolve(v)
+	if isNilPointer(v) {
+		return "null"
+	}
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return value
+	case time.Time:
+		return value.Format(stringOrDefault(formatter.TimeFormat, time.RFC3339Nano))
+	case Level:
+		return value.String()
+	}
+	if !hasMarshaler(v) {
+		switch value := v.(type) {
+		case error:
+			return value.Error()
+		case fmt.Stringer:
+			return value.String()
+		}
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		default:
+			return fmt.Sprint(v)
+		}
+	}
+	encoder := &JsonEncoder{
+		TimeFormat:        formatter.TimeFormat,
+		DisableHTMLEscape: true,
+	}
+	encoded, err := encoder.AppendValue(nil, v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(encoded)
+}
+
+// logfmtKey replaces characters that are not allowed in logfmt keys
+// with underscores.
+func logfmtKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || isControl(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// logfmtNeedsQuote checks if a value must be quoted.
+func logfmtNeedsQuote(s string) bool {
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || isControl(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogfmt parses a single logfmt line into a dictionary.
+// All values are returned as strings, except for bare keys, which are
+// returned as boolean true.
+// Quoted values may contain Go-style escape sequences.
+func parseLogfmt(line string) map[string]interface{} {
+	kv := make(map[string]interface{})
+	i := 0
+	for i < len(line) {
+		// skip whitespace
+		for i < len(line) && line[i] <= ' ' {
+			i++
+		}
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			if key != "" {
+				kv[key] = true
+			} else if i < len(line) {
+				// stray character, skip it
+				i++
+			}
+			continue
+		}
+		// skip '='
+		i++
+		if i < len(line) && line[i] == '"' {
+			start = i
+			i++
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i > len(line) {
+				i = len(line)
+			}
+			end := i
+			value, err := strconv.Unquote(line[start:i] + `"`)
+			if err != nil {
+				// invalid escape sequence, keep it verbatim
+				value = line[start+1 : end]
+			}
+			// skip closing quote
+			i++
+			if key != "" {
+				kv[key] = value
+			}
+		} else {
+			start = i
+			for i < len(line) && line[i] > ' ' {
+				i++
+			}
+			if key != "" {
+				kv[key] = line[start:i]
+			}
+		}
+	}
+	return kv
+}
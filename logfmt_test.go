@@ -0,0 +1,104 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	r01 := parseLogfmt("")
+	if len(r01) != 0 {
+		t.Errorf("t01: result should be empty")
+	}
+
+	r02 := parseLogfmt(`message="hello \"world\"\n" count=99 debug  empty=`)
+	x02 := map[string]interface{}{
+		"message": "hello \"world\"\n",
+		"count":   "99",
+		"debug":   true,
+		"empty":   "",
+	}
+	if !reflect.DeepEqual(r02, x02) {
+		t.Errorf("t02: invalid result: %v", r02)
+	}
+}
+
+func TestParseLogfmtEscapes(t *testing.T) {
+	r01 := parseLogfmt(`a="\x1b[31m" b="\u2028" c="unterminated`)
+	x01 := map[string]interface{}{
+		"a": "\x1b[31m",
+		"b": "\u2028",
+		"c": "unterminated",
+	}
+	if !reflect.DeepEqual(r01, x01) {
+		t.Errorf("t01: invalid result: %q", r01)
+	}
+
+	r02 := parseLogfmt(`a="bad \q escape"`)
+	if r02["a"] != `bad \q escape` {
+		t.Errorf("t02: invalid escapes should be kept verbatim: %q", r02["a"])
+	}
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	formatter := &LogfmtFormatter{
+		LeadingKeys: StdLeadingKeys,
+	}
+
+	formatter.Formatd(map[string]interface{}{}, buffer)
+	if buffer.String() != "\n" {
+		t.Errorf("f01: invalid output: %q", buffer.String())
+	}
+
+	buffer.Reset()
+	formatter.Formatd(map[string]interface{}{
+		StdMessageKey: "hello world",
+		StdTimeKey:    time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC),
+		LevelKey:      LevelInfo,
+		"count":       42,
+		"empty":       "",
+		"err":         errors.New("failed"),
+		"nil":         nil,
+		"map":         map[string]interface{}{"a": 1},
+		"bad key":     "x=y",
+		"inject":      "a\nfake=1",
+	}, buffer)
+	x02 := `time=2018-01-02T03:04:05Z level=info message="hello world" bad_key="x=y" count=42 empty= err=failed inject="a\nfake=1" map="{\"a\":1}" nil=null` + "\n"
+	if buffer.String() != x02 {
+		t.Errorf("f02: invalid output: %q", buffer.String())
+	}
+
+	// round trip
+	r03 := parseLogfmt(buffer.String())
+	if r03[StdMessageKey] != "hello world" || r03["inject"] != "a\nfake=1" || r03["map"] != `{"a":1}` || len(r03) != 10 {
+		t.Errorf("f03: invalid round trip: %q", r03)
+	}
+}
@@ -38,6 +38,12 @@ const (
 	StdTimeKey = "time"
 )
 
+var (
+	// StdLeadingKeys is the order in which the standard keys should
+	// appear at the start of a record, for formatters that support it.
+	StdLeadingKeys = []string{StdTimeKey, LevelKey, StdMessageKey}
+)
+
 // Filter is the standard interface for a data processor.
 type Filter interface {
 	// Printd accepts dictionaries and processes them.
@@ -77,10 +83,23 @@ func (logger *Logger) Printd(dict map[string]interface{}) {
 	formatter.Formatd(dict, sink)
 }
 
+// Printr sends a record to the log.
+func (logger *Logger) Printr(record *Record) {
+	formatter := logger.Formatter
+	if formatter == nil {
+		formatter = &dummyFormatter{}
+	}
+	sink := logger.Sink
+	if sink == nil {
+		sink = os.Stdout
+	}
+	formatRecord(formatter, record, sink)
+}
+
 type dummyFormatter struct{}
 
 func (formatter *dummyFormatter) Formatd(dict map[string]interface{}, sink io.Writer) {
-	switch m := dict[StdMessageKey].(type) {
+	switch m := Resolve(dict[StdMessageKey]).(type) {
 	case string:
 		sink.Write([]byte(m))
 	case []byte:
@@ -89,3 +108,17 @@ func (formatter *dummyFormatter) Formatd(dict map[string]interface{}, sink io.Wr
 		sink.Write([]byte("Invalid type for log message"))
 	}
 }
+
+func (formatter *dummyFormatter) Formatr(record *Record, sink io.Writer) {
+	field, _ := record.Lookup(StdMessageKey)
+	switch {
+	case field.Type == FieldString:
+		io.WriteString(sink, field.String)
+	case field.Type == FieldAny:
+		formatter.Formatd(map[string]interface{}{
+			StdMessageKey: field.Interface,
+		}, sink)
+	default:
+		sink.Write([]byte("Invalid type for log message"))
+	}
+}
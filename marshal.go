@@ -0,0 +1,115 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"reflect"
+	"sync"
+)
+
+// LogMarshaler is implemented by types that know how to log themselves
+// as a small structured object.
+//
+// MarshalLog should call add for each field that should be logged.
+// Field values may be LogMarshalers themselves, which produces nested
+// objects.
+//
+// Example:
+//
+//	func (u *User) MarshalLog(add func(kvl.Field)) {
+//		add(kvl.Int("id", u.Id))
+//		add(kvl.String("name", u.Name))
+//	}
+type LogMarshaler interface {
+	MarshalLog(add func(field Field))
+}
+
+// MarshalerFunc logs a value of a type that was registered with
+// RegisterMarshaler, in the same way as LogMarshaler.MarshalLog.
+type MarshalerFunc func(value interface{}, add func(field Field))
+
+var (
+	marshalersMutex sync.RWMutex
+	marshalers      = make(map[reflect.Type]MarshalerFunc)
+)
+
+// RegisterMarshaler registers a function that logs values of the same
+// type as example. Use it for types that you can't add a MarshalLog
+// method to.
+//
+// The type must match exactly, so pointer and value types need to be
+// registered separately. Passing a nil function removes the registration.
+func RegisterMarshaler(example interface{}, marshaler MarshalerFunc) {
+	typ := reflect.TypeOf(example)
+	marshalersMutex.Lock()
+	defer marshalersMutex.Unlock()
+	if marshaler == nil {
+		delete(marshalers, typ)
+	} else {
+		marshalers[typ] = marshaler
+	}
+}
+
+// marshalLog sends the fields of a LogMarshaler or a value with a
+// registered marshaler to add, and returns true.
+// If the value has no marshaler, it returns false.
+// Nil pointers are never marshaled, so they are logged as null like
+// encoding/json does.
+func marshalLog(value interface{}, add func(field Field)) bool {
+	if value == nil || isNilPointer(value) {
+		return false
+	}
+	if marshaler, ok := value.(LogMarshaler); ok {
+		marshaler.MarshalLog(add)
+		return true
+	}
+	marshalersMutex.RLock()
+	marshaler, ok := marshalers[reflect.TypeOf(value)]
+	marshalersMutex.RUnlock()
+	if ok {
+		marshaler(value, add)
+	}
+	return ok
+}
+
+// hasMarshaler checks if a value can be passed to marshalLog.
+func hasMarshaler(value interface{}) bool {
+	if value == nil || isNilPointer(value) {
+		return false
+	}
+	if _, ok := value.(LogMarshaler); ok {
+		return true
+	}
+	marshalersMutex.RLock()
+	_, ok := marshalers[reflect.TypeOf(value)]
+	marshalersMutex.RUnlock()
+	return ok
+}
+
+// isNilPointer checks if a value is a typed nil pointer.
+func isNilPointer(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+)
+
+type marshalTestUser struct {
+	id      int
+	name    string
+	address *marshalTestAddress
+}
+
+func (user *marshalTestUser) MarshalLog(add func(field Field)) {
+	add(Int("id", user.id))
+	add(String("name", user.name))
+	if user.address != nil {
+		add(Any("address", user.address))
+	}
+}
+
+type marshalTestAddress struct {
+	city string
+}
+
+func (address *marshalTestAddress) MarshalLog(add func(field Field)) {
+	add(String("city", address.city))
+}
+
+func TestLogMarshaler(t *testing.T) {
+	user := &marshalTestUser{
+		id:   42,
+		name: "bob",
+		address: &marshalTestAddress{
+			city: "Zurich",
+		},
+	}
+
+	b01 := &bytes.Buffer{}
+	f01 := &JsonFormatter{}
+	f01.Formatd(map[string]interface{}{"message": "test01", "user": user}, b01)
+	x01 := "{\"message\":\"test01\",\"user\":{\"address\":{\"city\":\"Zurich\"},\"id\":42,\"name\":\"bob\"}}\n"
+	if b01.String() != x01 {
+		t.Errorf("t01: no match. expected: '%s' got: '%s'", x01, b01)
+	}
+
+	b02 := &bytes.Buffer{}
+	f02 := &JsonFormatter{
+		JsonEncoder: JsonEncoder{
+			KeyOrder: KeyOrderInsertion,
+		},
+	}
+	r02 := newRecord([]Field{String("message", "test02"), Any("user", user)})
+	f02.Formatr(r02, b02)
+	r02.release()
+	x02 := "{\"message\":\"test02\",\"user\":{\"id\":42,\"name\":\"bob\",\"address\":{\"city\":\"Zurich\"}}}\n"
+	if b02.String() != x02 {
+		t.Errorf("t02: no match. expected: '%s' got: '%s'", x02, b02)
+	}
+
+	b03 := &bytes.Buffer{}
+	f03 := &ConsoleFormatter{
+		PrintKeys: true,
+	}
+	f03.Formatd(map[string]interface{}{"message": "test03", "user": user}, b03)
+	x03 := "test03 | user: {id: 42, name: bob, address: {city: Zurich}}\n"
+	if b03.String() != x03 {
+		t.Errorf("t03: no match. expected: '%s' got: '%s'", x03, b03)
+	}
+
+	var u04 *marshalTestUser
+	b04 := &bytes.Buffer{}
+	f04 := &JsonFormatter{}
+	f04.Formatd(map[string]interface{}{"message": "test04", "user": u04}, b04)
+	x04 := "{\"message\":\"test04\",\"user\":null}\n"
+	if b04.String() != x04 {
+		t.Errorf("t04: nil pointers should be null. expected: '%s' got: '%s'", x04, b04)
+	}
+
+	b05 := &bytes.Buffer{}
+	f05 := &ConsoleFormatter{
+		PrintKeys: true,
+	}
+	f05.Formatd(map[string]interface{}{"message": "test05", "user": u04}, b05)
+	x05 := "test05 | user: <nil>\n"
+	if b05.String() != x05 {
+		t.Errorf("t05: no match. expected: '%s' got: '%s'", x05, b05)
+	}
+
+	b06 := &bytes.Buffer{}
+	f06 := &LogfmtFormatter{}
+	f06.Formatd(map[string]interface{}{"message": "test06", "user": u04}, b06)
+	x06 := "message=test06 user=null\n"
+	if b06.String() != x06 {
+		t.Errorf("t06: no match. expected: '%s' got: '%s'", x06, b06)
+	}
+
+	kv07 := map[string]interface{}{"user": u04}
+	f07 := &FlattenFilter{}
+	f07.Printd(kv07)
+	if len(kv07) != 1 || kv07["user"] != u04 {
+		t.Errorf("t07: nil pointers should not be flattened: %v", kv07)
+	}
+}
+
+func TestRegisterMarshaler(t *testing.T) {
+	RegisterMarshaler(&url.URL{}, func(value interface{}, add func(field Field)) {
+		u := value.(*url.URL)
+		add(String("host", u.Host))
+		add(String("path", u.Path))
+	})
+	defer RegisterMarshaler(&url.URL{}, nil)
+
+	u, _ := url.Parse("https://example.com/index.html?secret=1")
+	b01 := &bytes.Buffer{}
+	f01 := &ConsoleFormatter{
+		PrintKeys: true,
+	}
+	f01.Formatd(map[string]interface{}{"message": "test01", "url": u}, b01)
+	x01 := "test01 | url: {host: example.com, path: /index.html}\n"
+	if b01.String() != x01 {
+		t.Errorf("t01: no match. expected: '%s' got: '%s'", x01, b01)
+	}
+
+	b02 := &bytes.Buffer{}
+	f02 := &JsonFormatter{}
+	f02.Formatd(map[string]interface{}{"url": *u}, b02)
+	if bytes.Contains(b02.Bytes(), []byte("\"host\"")) {
+		t.Errorf("t02: marshaler was used for a different type: %s", b02)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// LoggerKey is the key that holds the name of a NamedLogger.
+	// Type: string
+	LoggerKey = "logger"
+)
+
+var (
+	defaultHierarchy     *Hierarchy
+	defaultHierarchyOnce sync.Once
+)
+
+// Hierarchy is a tree of named loggers, like in log4j or Python logging.
+//
+// Names are separated by dots: "db" is the parent of "db.pool", and
+// the root logger with the empty name is the parent of "db".
+// Each logger inherits the level, filters and output of its parent, and
+// can override them. This allows silencing or debugging a single
+// subsystem without affecting the others.
+type Hierarchy struct {
+	mutex   sync.Mutex
+	root    *NamedLogger
+	loggers map[string]*NamedLogger
+	// lookup reads level overrides from the environment, if set
+	lookup func(string) (string, bool)
+}
+
+// NewHierarchy creates a hierarchy that sends all records to output,
+// unless a logger sets a different output.
+func NewHierarchy(output Filter) *Hierarchy {
+	hierarchy := &Hierarchy{
+		loggers: make(map[string]*NamedLogger),
+	}
+	hierarchy.root = newNamedLogger(hierarchy, "", nil)
+	hierarchy.root.output = output
+	hierarchy.mutex.Lock()
+	defer hierarchy.mutex.Unlock()
+	hierarchy.update(hierarchy.root)
+	return hierarchy
+}
+
+// DefaultHierarchy returns the hierarchy used by Named.
+//
+// It is created on first use. Its output is configured from environment
+// variables like NewFromEnv, and the levels of its loggers are taken
+// from KVL_LEVEL for the root, and from KVL_LEVEL_<name> for the others.
+// Invalid settings are ignored and logged as errors.
+// The output file, if any, stays open until the program exits.
+func DefaultHierarchy() *Hierarchy {
+	defaultHierarchyOnce.Do(func() {
+		defaultHierarchy = newEnvHierarchy(os.LookupEnv)
+	})
+	return defaultHierarchy
+}
+
+// Named returns the logger with a name from the default hierarchy,
+// and creates it and its parents if necessary.
+func Named(name string) *NamedLogger {
+	return DefaultHierarchy().Named(name)
+}
+
+// newEnvHierarchy creates a hierarchy that is configured from the environment.
+func newEnvHierarchy(lookup func(string) (string, bool)) *Hierarchy {
+	// levels are handled by the hierarchy, not by the output
+	output, err := newFromEnv("", func(key string) (string, bool) {
+		if key == EnvLevel || strings.HasPrefix(key, EnvLevel+"_") {
+			return "", false
+		}
+		return lookup(key)
+	})
+	hierarchy := NewHierarchy(output.Logger)
+	hierarchy.lookup = lookup
+	if value, ok := lookup(EnvLevel); ok && value != "" {
+		if level, perr := ParseLevel(value); perr != nil {
+			err = firstError(err, fmt.Errorf("%s: invalid level: %s", EnvLevel, value))
+		} else {
+			hierarchy.root.SetLevel(level)
+		}
+	}
+	hierarchy.report(err)
+	return hierarchy
+}
+
+// Named returns the logger with a name, and creates it and its parents
+// if necessary. The empty name refers to the root logger.
+func (hierarchy *Hierarchy) Named(name string) *NamedLogger {
+	hierarchy.mutex.Lock()
+	logger, err := hierarchy.named(name)
+	hierarchy.mutex.Unlock()
+	hierarchy.report(err)
+	return logger
+}
+
+// Root returns the root logger.
+func (hierarchy *Hierarchy) Root() *NamedLogger {
+	return hierarchy.root
+}
+
+// Loggers returns all loggers that were created so far, ordered by name.
+func (hierarchy *Hierarchy) Loggers() []*NamedLogger {
+	hierarchy.mutex.Lock()
+	defer hierarchy.mutex.Unlock()
+	loggers := make([]*NamedLogger, 0, len(hierarchy.loggers)+1)
+	loggers = append(loggers, hierarchy.root)
+	for _, logger := range hierarchy.loggers {
+		loggers = append(loggers, logger)
+	}
+	sort.Slice(loggers, func(i, j int) bool {
+		return loggers[i].name < loggers[j].name
+	})
+	return loggers
+}
+
+// named looks up or creates a logger.
+// Must be called with the mutex held.
+func (hierarchy *Hierarchy) named(name string) (*NamedLogger, error) {
+	if name == "" {
+		return hierarchy.root, nil
+	}
+	if logger, ok := hierarchy.loggers[name]; ok {
+		return logger, nil
+	}
+	var parentName string
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		parentName = name[:i]
+	}
+	parent, err := hierarchy.named(parentName)
+	logger := newNamedLogger(hierarchy, name, parent)
+	parent.children = append(parent.children, logger)
+	hierarchy.loggers[name] = logger
+	if hierarchy.lookup != nil {
+		if key, value, ok := envNamedLevel(name, hierarchy.lookup); ok {
+			if level, perr := ParseLevel(value); perr != nil {
+				err = firstError(err, fmt.Errorf("%s: invalid level: %s", key, value))
+			} else {
+				logger.level = level
+				logger.explicit = true
+			}
+		}
+	}
+	hierarchy.update(logger)
+	return logger, err
+}
+
+// update recomputes the effective settings of a logger and its descendants.
+// Must be called with the mutex held.
+func (hierarchy *Hierarchy) update(logger *NamedLogger) {
+	chain := &namedChain{
+		filters: logger.filters,
+		output:  logger.output,
+	}
+	level := logger.level
+	if parent := logger.parent; parent != nil {
+		inherited := parent.chain.Load().(*namedChain)
+		if !logger.isolated {
+			chain.filters = append(append([]Filter(nil), logger.filters...), inherited.filters...)
+		}
+		if chain.output == nil {
+			chain.output = inherited.output
+		}
+		if !logger.explicit {
+			level = parent.levels.CurrentThreshold()
+		}
+	}
+	logger.chain.Store(chain)
+	logger.levels.SetThreshold(level)
+	for _, child := range logger.children {
+		hierarchy.update(child)
+	}
+}
+
+// report logs a configuration error to the root logger.
+func (hierarchy *Hierarchy) report(err error) {
+	if err != nil {
+		hierarchy.root.Printr(Lvl(LevelError), String(StdMessageKey, "invalid logging configuration"), Err(err))
+	}
+}
+
+// firstError returns err if it is not nil, and next otherwise.
+func firstError(err error, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
+
+// NamedLogger is a logger in a Hierarchy.
+//
+// It provides the same frontend as StdLogger, and adds LoggerKey with its
+// name to each record that does not have one yet. Records below the effective level are dropped, then
+// the logger's own filters are applied, followed by the filters of its
+// ancestors, and the result is sent to the nearest output.
+//
+// NamedLoggers are created with Named or Hierarchy.Named and live as long
+// as their hierarchy. All settings may be changed while logging.
+type NamedLogger struct {
+	StdLogger
+	hierarchy *Hierarchy
+	name      string
+	parent    *NamedLogger
+	// levels drops records below the effective level
+	levels *LevelFilter
+	// chain holds the effective *namedChain
+	chain atomic.Value
+
+	// settings, protected by the hierarchy's mutex
+	children []*NamedLogger
+	level    Level
+	explicit bool
+	filters  []Filter
+	isolated bool
+	output   Filter
+}
+
+// namedChain holds the effective filters and output of a NamedLogger.
+type namedChain struct {
+	filters []Filter
+	output  Filter
+}
+
+// namedTail processes the records that passed the level check.
+type namedTail struct {
+	logger *NamedLogger
+}
+
+func newNamedLogger(hierarchy *Hierarchy, name string, parent *NamedLogger) *NamedLogger {
+	logger := &NamedLogger{
+		hierarchy: hierarchy,
+		name:      name,
+		parent:    parent,
+	}
+	logger.levels = &LevelFilter{
+		Logger: &namedTail{logger},
+	}
+	logger.StdLogger.Logger = logger.levels
+	return logger
+}
+
+// Name returns the full name of the logger.
+func (logger *NamedLogger) Name() string {
+	return logger.name
+}
+
+// Parent returns the parent of the logger, or nil for the root logger.
+func (logger *NamedLogger) Parent() *NamedLogger {
+	return logger.parent
+}
+
+// Named returns a descendant of this logger. The name is relative,
+// so Named("db").Named("pool") is the same as Named("db.pool").
+func (logger *NamedLogger) Named(name string) *NamedLogger {
+	if logger.name == "" {
+		return logger.hierarchy.Named(name)
+	}
+	return logger.hierarchy.Named(logger.name + "." + name)
+}
+
+// Level returns the effective level of the logger, and whether it was
+// set on this logger rather than inherited.
+func (logger *NamedLogger) Level() (Level, bool) {
+	logger.hierarchy.mutex.Lock()
+	defer logger.hierarchy.mutex.Unlock()
+	return logger.levels.CurrentThreshold(), logger.explicit
+}
+
+// SetLevel sets the level of the logger and of all descendants that
+// do not have a level of their own.
+func (logger *NamedLogger) SetLevel(level Level) {
+	logger.hierarchy.mutex.Lock()
+	defer logger.hierarchy.mutex.Unlock()
+	logger.level = level
+	logger.explicit = true
+	logger.hierarchy.update(logger)
+}
+
+// ResetLevel makes the logger inherit the level of its parent again.
+// The root logger passes all levels after a reset.
+func (logger *NamedLogger) ResetLevel() {
+	logger.hierarchy.mutex.Lock()
+	defer logger.hierarchy.mutex.Unlock()
+	logger.level = LevelTrace
+	logger.explicit = false
+	logger.hierarchy.update(logger)
+}
+
+// SetFilters replaces the filters of this logger. They are applied
+// before the filters inherited from the parent.
+func (logger *NamedLogger) SetFilters(filters ...Filter) {
+	logger.hierarchy.mutex.Lock()
+	defer logger.hierarchy.mutex.Unlock()
+	logger.filters = append([]Filter(nil), filters...)
+	logger.hierarchy.update(logger)
+}
+
+// SetInheritFilters determines if the filters of the ancestors are
+// applied after the logger's own filters. This is the default.
+func (logger *NamedLogger) SetInheritFilters(inherit bool) {
+	logger.hierarchy.mutex.Lock()
+	defer logger.hierarchy.mutex.Unlock()
+	logger.isolated = !inherit
+	logger.hierarchy.update(logger)
+}
+
+// SetOutput sends the records of this logger and its descendants to
+// output instead of the output of the parent. nil restores inheritance.
+func (logger *NamedLogger) SetOutput(output Filter) {
+	logger.hierarchy.mutex.Lock()
+	defer logger.hierarchy.mutex.Unlock()
+	logger.output = output
+	logger.hierarchy.update(logger)
+}
+
+// LevelEnabled checks the effective level and the output.
+func (logger *NamedLogger) LevelEnabled(level Level) bool {
+	return logger.levels.LevelEnabled(level)
+}
+
+func (tail *namedTail) Printd(kv map[string]interface{}) {
+	if _, ok := kv[LoggerKey]; !ok && tail.logger.name != "" {
+		kv[LoggerKey] = tail.logger.name
+	}
+	chain := tail.logger.chain.Load().(*namedChain)
+	for _, filter := range chain.filters {
+		filter.Printd(kv)
+	}
+	if chain.output != nil {
+		chain.output.Printd(kv)
+	}
+}
+
+func (tail *namedTail) Printr(record *Record) {
+	if _, ok := record.Lookup(LoggerKey); !ok && tail.logger.name != "" {
+		record.Set(String(LoggerKey, tail.logger.name))
+	}
+	chain := tail.logger.chain.Load().(*namedChain)
+	for _, filter := range chain.filters {
+		printRecord(filter, record)
+	}
+	printRecord(chain.output, record)
+}
+
+func (tail *namedTail) LevelEnabled(level Level) bool {
+	return levelEnabled(tail.logger.chain.Load().(*namedChain).output, level)
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNamedLevels(t *testing.T) {
+	output := &recordFilter{}
+	hierarchy := NewHierarchy(output)
+	pool := hierarchy.Named("db.pool")
+	db := hierarchy.Named("db")
+	if pool.Parent() != db || db.Parent() != hierarchy.Root() || db.Named("pool") != pool {
+		t.Errorf("t01: invalid hierarchy")
+	}
+
+	pool.Printkv(LevelKey, LevelTrace, StdMessageKey, "test01")
+	if len(output.records) != 1 || output.records[0][LoggerKey] != "db.pool" {
+		t.Errorf("t01: records should carry the logger name: %v", output.records)
+	}
+	pool.Printkv(LevelKey, LevelTrace, StdMessageKey, "test01", LoggerKey, "custom")
+	if len(output.records) != 2 || output.records[1][LoggerKey] != "custom" {
+		t.Errorf("t01: existing logger keys should be kept: %v", output.records)
+	}
+	output.records = output.records[:1]
+
+	hierarchy.Root().SetLevel(LevelWarn)
+	pool.Printkv(LevelKey, LevelInfo, StdMessageKey, "test02")
+	if len(output.records) != 1 || pool.LevelEnabled(LevelInfo) {
+		t.Errorf("t02: the level should be inherited from the root")
+	}
+
+	db.SetLevel(LevelDebug)
+	pool.Printkv(LevelKey, LevelDebug, StdMessageKey, "test03")
+	if level, explicit := pool.Level(); len(output.records) != 2 || level != LevelDebug || explicit {
+		t.Errorf("t03: the level should be inherited from the parent")
+	}
+
+	pool.SetLevel(LevelError)
+	db.ResetLevel()
+	if level, _ := pool.Level(); level != LevelError || db.LevelEnabled(LevelInfo) {
+		t.Errorf("t04: explicit levels should override inherited ones")
+	}
+	pool.ResetLevel()
+	if level, explicit := pool.Level(); level != LevelWarn || explicit {
+		t.Errorf("t05: reset levels should be inherited again: %v", level)
+	}
+
+	if loggers := hierarchy.Loggers(); len(loggers) != 3 || loggers[0].Name() != "" || loggers[2].Name() != "db.pool" {
+		t.Errorf("t06: invalid logger list")
+	}
+}
+
+func TestNamedFilters(t *testing.T) {
+	output := &recordFilter{}
+	hierarchy := NewHierarchy(output)
+	hierarchy.Root().SetFilters(&MergeFilter{Dict: map[string]interface{}{"root": true}})
+	db := hierarchy.Named("db")
+	db.SetFilters(&MergeFilter{Dict: map[string]interface{}{"db": true}})
+	pool := hierarchy.Named("db.pool")
+
+	pool.Printr(String(StdMessageKey, "test01"))
+	r01 := output.records[0]
+	if r01["root"] != true || r01["db"] != true || r01[LoggerKey] != "db.pool" {
+		t.Errorf("t01: filters should be inherited: %v", r01)
+	}
+
+	pool.SetInheritFilters(false)
+	pool.Printd(map[string]interface{}{StdMessageKey: "test02"})
+	if _, ok := output.records[1]["db"]; ok {
+		t.Errorf("t02: filters should not be inherited")
+	}
+
+	other := &recordFilter{}
+	db.SetOutput(other)
+	pool.Print("test03")
+	hierarchy.Named("cache").Print("test04")
+	if len(other.records) != 1 || len(output.records) != 3 {
+		t.Errorf("t03: the output should be overridden for descendants")
+	}
+	db.SetOutput(nil)
+	pool.Print("test05")
+	if len(output.records) != 4 {
+		t.Errorf("t04: the output should be inherited again")
+	}
+
+	hierarchy.Root().SetLevel(LevelError)
+	a05 := testing.AllocsPerRun(100, func() {
+		pool.Printr(Lvl(LevelDebug), String(StdMessageKey, "test05"))
+	})
+	if a05 != 0 {
+		t.Errorf("t05: disabled level allocated %v times", a05)
+	}
+}
+
+func TestNamedEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.log")
+
+	hierarchy := newEnvHierarchy(envLookup(map[string]string{
+		EnvFormat:            "json",
+		EnvOutput:            path,
+		EnvLevel:             "warn",
+		EnvLevel + "_db":     "debug",
+		EnvLevel + "_DB_BAD": "loud",
+	}))
+	if level, _ := hierarchy.Root().Level(); level != LevelWarn {
+		t.Errorf("t01: the root level should be taken from %s", EnvLevel)
+	}
+	if level, explicit := hierarchy.Named("db.pool").Level(); level != LevelDebug || explicit {
+		t.Errorf("t02: the named level should be inherited")
+	}
+	hierarchy.Named("db.bad").Printr(Lvl(LevelTrace), String(StdMessageKey, "dropped"))
+	hierarchy.Named("db").Printr(Lvl(LevelDebug), String(StdMessageKey, "logged"))
+	r03, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(r03)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "DB_BAD") || !strings.Contains(lines[1], `"logger":"db"`) {
+		t.Errorf("t03: invalid output: %s", r03)
+	}
+}
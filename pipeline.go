@@ -0,0 +1,373 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Pipeline holds a filter graph that can be replaced while the program
+// is running, for example to change the log level or add a debug sink.
+//
+// Each record passes through exactly one graph: Records logged after a
+// replacement go to the new graph, while records that are already in the
+// old graph finish there. Nothing is lost or logged twice. Logging never
+// waits for a replacement, and replacing never waits for a slow sink,
+// except for Swap, which returns the old graph once it is idle.
+//
+// Graphs loaded with Reload, ReloadFile, WatchFile or ReloadOnSignal are
+// owned by the Pipeline, which closes their files and flushes filters like
+// DedupFilter in the background, once the last record has left them.
+// Graphs passed to Swap and Override are owned by the caller.
+// Call Close before the program exits, so the current graph is closed as
+// well and nothing is lost.
+//
+// Filters in the graph must not log to the Pipeline that contains them.
+type Pipeline struct {
+	// mutex protects current, and is only held while looking it up.
+	mutex sync.RWMutex
+	// control serializes replacements.
+	control sync.Mutex
+	current *pipelineStage
+	// base is the graph that is restored when the current override expires.
+	// It is nil if no override is active.
+	base  *pipelineStage
+	timer *time.Timer
+	// retiring counts graphs that are waiting to be closed.
+	retiring sync.WaitGroup
+}
+
+// pipelineStage is one generation of the filter graph.
+type pipelineStage struct {
+	filter  Filter
+	closers []io.Closer
+	// active counts the records passing through the graph.
+	active sync.WaitGroup
+}
+
+// NewPipeline creates a Pipeline with an initial filter graph.
+func NewPipeline(filter Filter) *Pipeline {
+	return &Pipeline{
+		current: &pipelineStage{filter: filter},
+	}
+}
+
+func (pipeline *Pipeline) Printd(kv map[string]interface{}) {
+	stage := pipeline.enter()
+	if stage == nil {
+		return
+	}
+	defer stage.active.Done()
+	if stage.filter != nil {
+		stage.filter.Printd(kv)
+	}
+}
+
+func (pipeline *Pipeline) Printr(record *Record) {
+	stage := pipeline.enter()
+	if stage == nil {
+		return
+	}
+	defer stage.active.Done()
+	if stage.filter != nil {
+		printRecord(stage.filter, record)
+	}
+}
+
+// enter returns the current graph and counts a record passing through it.
+// The caller must call Done on its active counter when the record has
+// left the graph.
+func (pipeline *Pipeline) enter() *pipelineStage {
+	pipeline.mutex.RLock()
+	defer pipeline.mutex.RUnlock()
+	stage := pipeline.current
+	if stage != nil {
+		stage.active.Add(1)
+	}
+	return stage
+}
+
+// LevelEnabled asks the current graph.
+func (pipeline *Pipeline) LevelEnabled(level Level) bool {
+	pipeline.mutex.RLock()
+	defer pipeline.mutex.RUnlock()
+	return pipeline.current == nil || levelEnabled(pipeline.current.filter, level)
+}
+
+// Filter returns the current filter graph.
+func (pipeline *Pipeline) Filter() Filter {
+	pipeline.mutex.RLock()
+	defer pipeline.mutex.RUnlock()
+	if pipeline.current == nil {
+		return nil
+	}
+	return pipeline.current.filter
+}
+
+// overridden checks if an override is active.
+func (pipeline *Pipeline) overridden() bool {
+	pipeline.control.Lock()
+	defer pipeline.control.Unlock()
+	return pipeline.base != nil
+}
+
+// Swap replaces the filter graph permanently and cancels an active override.
+// The previous graph is returned once no records are passing through it
+// anymore, so the caller may close it. Graphs owned by the Pipeline are
+// closed in the background.
+func (pipeline *Pipeline) Swap(filter Filter) Filter {
+	pipeline.control.Lock()
+	previous := pipeline.current
+	pipeline.replace(&pipelineStage{filter: filter})
+	pipeline.control.Unlock()
+	if previous == nil {
+		return nil
+	}
+	previous.active.Wait()
+	return previous.filter
+}
+
+// Reload builds a new filter graph from a JSON configuration document,
+// as described in LoadConfig, and replaces the current graph with it.
+// If the document is invalid, the current graph stays in place.
+func (pipeline *Pipeline) Reload(reader io.Reader) error {
+	document, err := decodeConfig(reader)
+	if err != nil {
+		return err
+	}
+	return pipeline.ReloadDocument(document)
+}
+
+// ReloadDocument is like Reload, but takes an already decoded document,
+// as described in BuildConfig.
+func (pipeline *Pipeline) ReloadDocument(document interface{}) error {
+	filter, closers, err := buildConfig(document)
+	if err != nil {
+		return err
+	}
+	pipeline.control.Lock()
+	defer pipeline.control.Unlock()
+	pipeline.replace(&pipelineStage{filter: filter, closers: closers})
+	return nil
+}
+
+// ReloadFile is like Reload, but reads the document from a file.
+func (pipeline *Pipeline) ReloadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return pipeline.Reload(file)
+}
+
+// Override replaces the filter graph temporarily. After the timeout,
+// the graph that was in place before is restored.
+// Calling Override again while an override is active replaces the
+// override and restarts the timeout, but keeps the original graph.
+func (pipeline *Pipeline) Override(filter Filter, timeout time.Duration) {
+	pipeline.control.Lock()
+	defer pipeline.control.Unlock()
+	base := pipeline.base
+	if base == nil {
+		base = pipeline.current
+	}
+	// keep the base graph open while the override is active
+	pipeline.swap(&pipelineStage{filter: filter}, base)
+	pipeline.base = base
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		pipeline.control.Lock()
+		defer pipeline.control.Unlock()
+		// ignore timers that were cancelled after they fired
+		if pipeline.timer == timer {
+			pipeline.revert()
+		}
+	})
+	pipeline.timer = timer
+}
+
+// Revert ends an active override immediately and restores the graph that
+// was in place before. Returns false if no override was active.
+func (pipeline *Pipeline) Revert() bool {
+	pipeline.control.Lock()
+	defer pipeline.control.Unlock()
+	if pipeline.base == nil {
+		return false
+	}
+	pipeline.revert()
+	return true
+}
+
+func (pipeline *Pipeline) revert() {
+	base := pipeline.base
+	pipeline.stopTimer()
+	pipeline.base = nil
+	pipeline.swap(base, nil)
+}
+
+// replace installs a new graph permanently.
+// Must be called with the control mutex held.
+func (pipeline *Pipeline) replace(stage *pipelineStage) {
+	base := pipeline.base
+	pipeline.stopTimer()
+	pipeline.base = nil
+	pipeline.swap(stage, nil)
+	if base != nil {
+		pipeline.retire(base)
+	}
+}
+
+// swap installs a new graph and retires the previous one, unless it is keep.
+// Must be called with the control mutex held.
+func (pipeline *Pipeline) swap(stage *pipelineStage, keep *pipelineStage) {
+	pipeline.mutex.Lock()
+	previous := pipeline.current
+	pipeline.current = stage
+	pipeline.mutex.Unlock()
+	if previous != nil && previous != keep {
+		pipeline.retire(previous)
+	}
+}
+
+// retire closes a graph that is no longer current, once the last record
+// has left it. This happens in the background, so a slow sink does not
+// hold up replacements.
+func (pipeline *Pipeline) retire(stage *pipelineStage) {
+	if len(stage.closers) == 0 {
+		return
+	}
+	pipeline.retiring.Add(1)
+	go func() {
+		defer pipeline.retiring.Done()
+		stage.active.Wait()
+		closeAll(stage.closers)
+	}()
+}
+
+// Close ends an active override, closes the current graph and the graph
+// that was kept for the override, if they are owned by the Pipeline, and
+// waits until all replaced graphs are closed too.
+// Records logged after Close are dropped.
+// Returns the first error from closing the current graphs.
+func (pipeline *Pipeline) Close() error {
+	pipeline.control.Lock()
+	pipeline.stopTimer()
+	stages := []*pipelineStage{pipeline.current, pipeline.base}
+	pipeline.base = nil
+	pipeline.mutex.Lock()
+	pipeline.current = nil
+	pipeline.mutex.Unlock()
+	pipeline.control.Unlock()
+	var first error
+	for _, stage := range stages {
+		if stage == nil {
+			continue
+		}
+		stage.active.Wait()
+		if err := closeAll(stage.closers); err != nil && first == nil {
+			first = err
+		}
+	}
+	pipeline.retiring.Wait()
+	return first
+}
+
+func (pipeline *Pipeline) stopTimer() {
+	if pipeline.timer != nil {
+		pipeline.timer.Stop()
+		pipeline.timer = nil
+	}
+}
+
+// WatchFile checks a configuration file for changes in regular intervals,
+// and reloads it when its modification time or size changes.
+// The file is not loaded initially.
+// Errors are passed to report, if it is not nil.
+// Call the returned function to stop watching.
+func (pipeline *Pipeline) WatchFile(path string, interval time.Duration, report func(error)) (stop func()) {
+	info, _ := os.Stat(path)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				current, err := os.Stat(path)
+				if err != nil || info != nil && current.ModTime().Equal(info.ModTime()) && current.Size() == info.Size() {
+					// the file may be in the middle of being replaced,
+					// so a missing file is not an error
+					continue
+				}
+				info = current
+				if err := pipeline.ReloadFile(path); err != nil && report != nil {
+					report(err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
+
+// ReloadOnSignal reloads a configuration file whenever one of the signals
+// is received, typically syscall.SIGHUP.
+// Errors are passed to report, if it is not nil.
+// Call the returned function to stop listening.
+func (pipeline *Pipeline) ReloadOnSignal(path string, report func(error), signals ...os.Signal) (stop func()) {
+	notify := make(chan os.Signal, 1)
+	signal.Notify(notify, signals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-notify:
+				if err := pipeline.ReloadFile(path); err != nil && report != nil {
+					report(err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(notify)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countFilter counts the dictionaries it receives.
+type countFilter struct {
+	count int64
+}
+
+func (filter *countFilter) Printd(kv map[string]interface{}) {
+	atomic.AddInt64(&filter.count, 1)
+}
+
+// closeSink records if it was closed.
+type closeSink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (sink *closeSink) Close() error {
+	sink.closed = true
+	return nil
+}
+
+func TestPipelineSwap(t *testing.T) {
+	r01 := &recordFilter{}
+	r02 := &recordFilter{}
+	p01 := NewPipeline(r01)
+	p01.Printd(map[string]interface{}{"message": "one"})
+	if previous := p01.Swap(r02); previous != r01 {
+		t.Errorf("t01: the previous graph should be returned")
+	}
+	p01.Printd(map[string]interface{}{"message": "two"})
+	if len(r01.records) != 1 || len(r02.records) != 1 || p01.Filter() != r02 {
+		t.Errorf("t01: records should go to the current graph")
+	}
+
+	p02 := NewPipeline(&LevelFilter{Threshold: LevelWarn, Logger: r01})
+	if p02.LevelEnabled(LevelInfo) || !levelEnabled(p02, LevelError) {
+		t.Errorf("t02: levels should be taken from the current graph")
+	}
+}
+
+func TestPipelineConcurrent(t *testing.T) {
+	filters := []*countFilter{{}, {}, {}}
+	pipeline := NewPipeline(filters[0])
+	const writers = 8
+	const records = 1000
+	var wait sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < records; j++ {
+				pipeline.Printd(map[string]interface{}{"message": j})
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		pipeline.Swap(filters[i%len(filters)])
+	}
+	wait.Wait()
+	var total int64
+	for _, filter := range filters {
+		total += atomic.LoadInt64(&filter.count)
+	}
+	if total != writers*records {
+		t.Errorf("c01: expected %d records, got %d", writers*records, total)
+	}
+}
+
+// blockFilter blocks until release is closed.
+type blockFilter struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (filter *blockFilter) Printd(kv map[string]interface{}) {
+	close(filter.entered)
+	<-filter.release
+}
+
+func TestPipelineBlocked(t *testing.T) {
+	blocked := &blockFilter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	pipeline := NewPipeline(blocked)
+	go pipeline.Printd(map[string]interface{}{"message": "stuck"})
+	<-blocked.entered
+
+	r01 := &recordFilter{}
+	done := make(chan struct{})
+	go func() {
+		pipeline.Override(r01, time.Hour)
+		pipeline.Printd(map[string]interface{}{"message": "next"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("b01: a blocked sink should not stall replacements and logging")
+	}
+	if len(r01.records) != 1 {
+		t.Errorf("b01: the record should go to the new graph")
+	}
+
+	close(blocked.release)
+
+	b02 := &blockFilter{
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	pipeline.Swap(b02)
+	go pipeline.Printd(map[string]interface{}{"message": "stuck"})
+	<-b02.entered
+	swapped := make(chan Filter, 1)
+	go func() {
+		swapped <- pipeline.Swap(&recordFilter{})
+	}()
+	select {
+	case <-swapped:
+		t.Errorf("b02: swap should wait for records in the previous graph")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(b02.release)
+	select {
+	case previous := <-swapped:
+		if previous != b02 {
+			t.Errorf("b02: the previous graph should be returned")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("b02: swap should return once the previous graph is idle")
+	}
+}
+
+func TestPipelineReload(t *testing.T) {
+	var sinks []*closeSink
+	RegisterSink("pipelinetest", func(config *Config) (io.Writer, error) {
+		sink := &closeSink{}
+		sinks = append(sinks, sink)
+		return sink, nil
+	})
+	defer RegisterSink("pipelinetest", nil)
+	document := `{"type": "logger", "formatter": {"type": "logfmt"}, "sink": {"type": "pipelinetest"}}`
+
+	pipeline := NewPipeline(nil)
+	pipeline.Printd(map[string]interface{}{"message": "dropped"})
+	if err := pipeline.Reload(strings.NewReader(document)); err != nil {
+		t.Fatalf("r01: unexpected error: %v", err)
+	}
+	pipeline.Printd(map[string]interface{}{"message": "first"})
+	if err := pipeline.Reload(strings.NewReader(document)); err != nil {
+		t.Fatalf("r02: unexpected error: %v", err)
+	}
+	pipeline.Printd(map[string]interface{}{"message": "second"})
+	pipeline.retiring.Wait()
+	if len(sinks) != 2 || !sinks[0].closed || sinks[1].closed {
+		t.Errorf("r02: the replaced sink should be closed")
+	}
+	if sinks[0].String() != "message=first\n" || sinks[1].String() != "message=second\n" {
+		t.Errorf("r02: invalid output: %q %q", sinks[0].String(), sinks[1].String())
+	}
+
+	if err := pipeline.Reload(strings.NewReader(`{"type": "bogus"}`)); err == nil {
+		t.Errorf("r03: expected an error")
+	}
+	if sinks[1].closed {
+		t.Errorf("r03: an invalid document should keep the current graph")
+	}
+
+	// a sink that was opened before the error is closed again
+	err := pipeline.Reload(strings.NewReader(`{"type": "multi", "logger": {"type": "logger", "sink": {"type": "pipelinetest"}}, "bogus": 1}`))
+	if err == nil || len(sinks) != 3 || !sinks[2].closed {
+		t.Errorf("r04: partially built graphs should be closed")
+	}
+
+	pipeline.Override(&recordFilter{}, time.Hour)
+	if err := pipeline.Close(); err != nil || !sinks[1].closed {
+		t.Errorf("r05: the graph kept for the override should be closed: %v", err)
+	}
+	pipeline.Printd(map[string]interface{}{"message": "dropped"})
+	if pipeline.Filter() != nil || pipeline.Revert() {
+		t.Errorf("r05: the pipeline should be empty after closing")
+	}
+
+	closing := NewPipeline(nil)
+	if err := closing.Reload(strings.NewReader(document)); err != nil {
+		t.Fatalf("r06: unexpected error: %v", err)
+	}
+	if err := closing.Close(); err != nil || len(sinks) != 4 || !sinks[3].closed {
+		t.Errorf("r06: the current graph should be closed: %v", err)
+	}
+}
+
+func TestPipelineOverride(t *testing.T) {
+	base := &recordFilter{}
+	debug := &recordFilter{}
+	pipeline := NewPipeline(base)
+	if pipeline.Revert() {
+		t.Errorf("o01: no override should be active")
+	}
+
+	pipeline.Override(debug, time.Hour)
+	pipeline.Printd(map[string]interface{}{"message": "debug"})
+	if !pipeline.Revert() || pipeline.Filter() != base || len(debug.records) != 1 {
+		t.Errorf("o02: revert should restore the base graph")
+	}
+
+	pipeline.Override(debug, time.Hour)
+	pipeline.Override(&recordFilter{}, 10*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for pipeline.Filter() != base && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if pipeline.Filter() != base {
+		t.Errorf("o03: the override should expire")
+	}
+
+	other := &recordFilter{}
+	pipeline.Override(debug, 10*time.Millisecond)
+	pipeline.Swap(other)
+	time.Sleep(50 * time.Millisecond)
+	if pipeline.Filter() != other || pipeline.Revert() {
+		t.Errorf("o04: swapping should cancel the override")
+	}
+}
+
+func TestPipelineWatchFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.json")
+	if err := ioutil.WriteFile(path, []byte(`{"type": "logger", "sink": {"type": "discard"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	initial := &recordFilter{}
+	pipeline := NewPipeline(initial)
+	errs := make(chan error, 10)
+	stop := pipeline.WatchFile(path, time.Millisecond, func(err error) {
+		errs <- err
+	})
+	defer stop()
+	time.Sleep(20 * time.Millisecond)
+	if pipeline.Filter() != initial {
+		t.Errorf("w01: the file should not be loaded initially")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"type": "level", "threshold": "warn", "logger": {"type": "logger", "sink": {"type": "discard"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pipeline.Filter() == initial && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := pipeline.Filter().(*LevelFilter); !ok {
+		t.Errorf("w02: the changed file should be loaded")
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"type": "level", "bogus": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("w03: expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("w03: errors should be reported")
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
+	// prettyIndent is the indentation of each nesting level.
+	prettyIndent = "    "
+)
+
+var (
+	// processStart is the reference point for relative timestamps.
+	processStart = time.Now()
+)
+
+// PrettyFormatter produces multi-line, human-friendly output for
+// local development.
+//
+// Each record starts with a header line made from the time relative to
+// process start, an aligned level column and the message in bold.
+// Additional keys follow on their own lines, indented. Dictionaries,
+// lists and LogMarshalers are rendered as nested, indented blocks,
+// errors as their message, and durations and times in readable form.
+//
+// PrintTime, PrintKeys and SortKeys of the embedded ConsoleFormatter
+// have the same meaning as for ConsoleFormatter. Escape is applied to
+// all strings, but EscapeQuote is treated like EscapeControl.
+type PrettyFormatter struct {
+	ConsoleFormatter
+	// NoColor disables ANSI terminal attributes.
+	NoColor bool
+	// Start is the reference point for relative timestamps.
+	// Defaults to the time the program was started.
+	Start time.Time
+}
+
+func (formatter *PrettyFormatter) Formatd(dict map[string]interface{}, sink io.Writer) {
+	var out strings.Builder
+	if formatter.PrintTime {
+		switch t := Resolve(dict[StdTimeKey]).(type) {
+		case time.Time:
+			start := formatter.Start
+			if start.IsZero() {
+				start = processStart
+			}
+			fmt.Fprintf(&out, "%+11.3fs ", t.Sub(start).Seconds())
+		case string:
+			out.WriteString(formatter.escapeString(t))
+			out.WriteString(" ")
+		default:
+			out.WriteString(strings.Repeat(" ", 13))
+		}
+	}
+	if level, ok := LevelOf(Resolve(dict[LevelKey])); ok {
+		fmt.Fprintf(&out, "%-5s ", strings.ToUpper(level.String()))
+	} else {
+		out.WriteString("      ")
+	}
+	message, ok := Resolve(dict[StdMessageKey]).(string)
+	if !ok {
+		message = invalidMessageType
+	}
+	out.WriteString(formatter.attribute(ansiBold, formatter.escapeString(message)))
+	out.WriteString("\n")
+	if formatter.PrintKeys {
+		for _, k := range formatter.keys(dict) {
+			if skipKeys[k] || k == LevelKey {
+				continue
+			}
+			formatter.printValue(&out, prettyIndent, formatter.escapeString(k), dict[k], 0)
+		}
+	}
+	sink.Write([]byte(out.String()))
+}
+
+// keys returns the keys of a dictionary in output order.
+func (formatter *PrettyFormatter) keys(dict map[string]interface{}) []string {
+	if formatter.SortKeys {
+		return OrderedStringKeys(dict)
+	}
+	keys := make([]string, 0, len(dict))
+	for k := range dict {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// printValue writes a value on its own line, or as a nested block.
+func (formatter *PrettyFormatter) printValue(out *strings.Builder, indent, label string, v interface{}, depth int) {
+	v = Resolve(v)
+	if depth > maxJsonDepth {
+		fmt.Fprintf(out, "%s%s: ...\n", indent, label)
+		return
+	}
+	if hasMarshaler(v) {
+		dict := make(map[string]interface{})
+		var keys []string
+		marshalLog(v, func(field Field) {
+			keys = append(keys, field.Key)
+			dict[field.Key] = field.Value()
+		})
+		fmt.Fprintf(out, "%s%s:\n", indent, label)
+		for _, k := range keys {
+			formatter.printValue(out, indent+prettyIndent, formatter.escapeString(k), dict[k], depth+1)
+		}
+		return
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			fmt.Fprintf(out, "%s%s: {}\n", indent, label)
+			return
+		}
+		fmt.Fprintf(out, "%s%s:\n", indent, label)
+		for _, k := range formatter.keys(t) {
+			formatter.printValue(out, indent+prettyIndent, formatter.escapeString(k), t[k], depth+1)
+		}
+	case []interface{}:
+		if isScalarList(t) {
+			items := make([]string, len(t))
+			for i, e := range t {
+				items[i] = formatter.scalar(e)
+			}
+			fmt.Fprintf(out, "%s%s: [%s]\n", indent, label, strings.Join(items, ", "))
+			return
+		}
+		fmt.Fprintf(out, "%s%s:\n", indent, label)
+		for _, e := range t {
+			formatter.printValue(out, indent+prettyIndent, "-", e, depth+1)
+		}
+	case []string:
+		items := make([]string, len(t))
+		for i, e := range t {
+			items[i] = formatter.escapeString(e)
+		}
+		fmt.Fprintf(out, "%s%s: [%s]\n", indent, label, strings.Join(items, ", "))
+	case error:
+		// fmt.Sprint handles nil pointers, like ConsoleFormatter
+		fmt.Fprintf(out, "%s%s: %s\n", indent, label, formatter.attribute(ansiRed, formatter.escapeString(fmt.Sprint(t))))
+	default:
+		value := formatter.scalar(v)
+		// indent continuation lines of multi-line values
+		value = strings.Replace(value, "\n"+consoleContinuation, "\n"+indent+prettyIndent, -1)
+		fmt.Fprintf(out, "%s%s: %s\n", indent, label, value)
+	}
+}
+
+// scalar formats a value that fits on a single line.
+func (formatter *PrettyFormatter) scalar(v interface{}) string {
+	switch t := Resolve(v).(type) {
+	case time.Duration:
+		return t.String()
+	case time.Time:
+		return t.Format(ConsoleTimeFormat)
+	default:
+		return formatter.escapeString(fmt.Sprint(t))
+	}
+}
+
+// isScalarList checks if all elements of a list can be printed inline.
+func isScalarList(list []interface{}) bool {
+	for _, e := range list {
+		switch Resolve(e).(type) {
+		case map[string]interface{}, []interface{}, []string:
+			return false
+		}
+		if hasMarshaler(Resolve(e)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (formatter *PrettyFormatter) escapeString(s string) string {
+	// quoting makes no sense when each value is on its own line
+	if formatter.Escape == EscapeQuote {
+		return escapeControl(s, false)
+	}
+	return formatter.escape(s, partMessage)
+}
+
+// attribute wraps s in an ANSI attribute, unless colors are disabled.
+func (formatter *PrettyFormatter) attribute(attr, s string) string {
+	if formatter.NoColor {
+		return s
+	}
+	return attr + s + ansiReset
+}
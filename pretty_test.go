@@ -0,0 +1,126 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrettyFormatter(t *testing.T) {
+	start := time.Date(2018, 1, 31, 8, 59, 2, 0, time.UTC)
+
+	b01 := &bytes.Buffer{}
+	f01 := &PrettyFormatter{
+		ConsoleFormatter: ConsoleFormatter{
+			PrintTime: true,
+			PrintKeys: true,
+			SortKeys:  true,
+		},
+		NoColor: true,
+		Start:   start,
+	}
+	f01.Formatd(map[string]interface{}{
+		StdTimeKey:    start.Add(1500 * time.Millisecond),
+		LevelKey:      LevelInfo,
+		StdMessageKey: "test01",
+		"elapsed":     250 * time.Millisecond,
+		"error":       errors.New("failed"),
+		"http": map[string]interface{}{
+			"method": "GET",
+			"empty":  map[string]interface{}{},
+		},
+		"tags":  []interface{}{"a", 1},
+		"items": []interface{}{map[string]interface{}{"id": 1}},
+		"user":  &marshalTestUser{id: 42, name: "bob"},
+	}, b01)
+	x01 := `     +1.500s INFO  test01
+    elapsed: 250ms
+    error: failed
+    http:
+        empty: {}
+        method: GET
+    items:
+        -:
+            id: 1
+    tags: [a, 1]
+    user:
+        id: 42
+        name: bob
+`
+	if b01.String() != x01 {
+		t.Errorf("t01: no match. expected: '%s' got: '%s'", x01, b01)
+	}
+
+	b02 := &bytes.Buffer{}
+	f02 := &PrettyFormatter{}
+	f02.Formatd(map[string]interface{}{
+		StdMessageKey: "test02\x1b",
+		"hidden":      1,
+	}, b02)
+	x02 := "      \x1b[1mtest02\\x1b\x1b[0m\n"
+	if b02.String() != x02 {
+		t.Errorf("t02: no match. expected: '%q' got: '%q'", x02, b02)
+	}
+
+	b03 := &bytes.Buffer{}
+	f03 := &PrettyFormatter{
+		ConsoleFormatter: ConsoleFormatter{
+			PrintKeys: true,
+			Escape:    EscapeMultiline,
+		},
+		NoColor: true,
+	}
+	f03.Formatd(map[string]interface{}{
+		StdMessageKey: "test03",
+		LevelKey:      "error",
+		"nested":      map[string]interface{}{"stack": "a()\nb()"},
+	}, b03)
+	x03 := "ERROR test03\n    nested:\n        stack: a()\n            b()\n"
+	if b03.String() != x03 {
+		t.Errorf("t03: no match. expected: '%s' got: '%s'", x03, b03)
+	}
+
+	b04 := &bytes.Buffer{}
+	f04 := &PrettyFormatter{
+		ConsoleFormatter: ConsoleFormatter{
+			PrintKeys: true,
+			SortKeys:  true,
+		},
+		NoColor: true,
+	}
+	var e04 error = (*errorTest)(nil)
+	f04.Formatd(map[string]interface{}{
+		StdMessageKey: "test04",
+		"error":       e04,
+		"errors":      []interface{}{e04},
+	}, b04)
+	x04 := "      test04\n    error: <nil>\n    errors: [<nil>]\n"
+	if b04.String() != x04 {
+		t.Errorf("t04: no match. expected: '%s' got: '%s'", x04, b04)
+	}
+}
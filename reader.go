@@ -0,0 +1,325 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReadFormat is the log format parsed by Reader.
+type ReadFormat int
+
+const (
+	// ReadAuto detects the format of each line. This is the default.
+	// Lines starting with '{' are parsed as JSON, lines that start with
+	// a '[' or contain " | " as console output, and lines with key=value
+	// pairs as logfmt. Everything else is taken as a console message.
+	ReadAuto ReadFormat = iota
+	// ReadJson parses the output of JsonFormatter.
+	ReadJson
+	// ReadConsole parses the output of ConsoleFormatter.
+	ReadConsole
+	// ReadLogfmt parses the output of LogfmtFormatter.
+	ReadLogfmt
+)
+
+// ReadError is a line that could not be parsed.
+type ReadError struct {
+	Line    int
+	Message string
+}
+
+func (err *ReadError) Error() string {
+	return fmt.Sprintf("line %d: %s", err.Line, err.Message)
+}
+
+// Reader parses log streams back into dictionaries, for replay, analysis
+// and conversion tools.
+//
+// JSON numbers are returned as json.Number and other values as decoded by
+// encoding/json. Console and logfmt values are returned as strings, except
+// for bare logfmt keys, which are true. Values that were quoted by
+// EscapeQuote are unquoted. If StdTimeKey can be parsed as a time, it is
+// restored as time.Time.
+//
+// Console output is ambiguous if messages or values contain " | " and
+// were not quoted, so use JSON if the logs need to be read back reliably.
+// Continuation lines written by EscapeMultiline are joined with the
+// previous line.
+type Reader struct {
+	// Format is the format of the stream.
+	Format ReadFormat
+	// TimeFormat is the layout of StdTimeKey in JSON and logfmt streams.
+	// Defaults to time.RFC3339Nano, which also accepts time.RFC3339.
+	TimeFormat string
+	// Location is the time zone of console timestamps, which do not
+	// contain one. Defaults to time.Local.
+	Location *time.Location
+
+	scanner *bufio.Scanner
+	line    int
+	peeked  *readerLine
+	// skipping is set while the rest of an overlong line is discarded.
+	skipping bool
+	// tooLong is set if the last token was cut off at maxLineLength.
+	tooLong bool
+}
+
+// readerLine is a line that was read ahead.
+type readerLine struct {
+	text    string
+	tooLong bool
+}
+
+// NewReader creates a Reader for a stream.
+func NewReader(stream io.Reader) *Reader {
+	reader := &Reader{
+		scanner: bufio.NewScanner(stream),
+	}
+	reader.scanner.Buffer(nil, maxLineLength)
+	reader.scanner.Split(reader.split)
+	return reader
+}
+
+// split is a bufio.SplitFunc like bufio.ScanLines, except that lines longer
+// than maxLineLength are cut off and the rest is skipped, instead of
+// failing the whole stream.
+func (reader *Reader) split(data []byte, atEOF bool) (int, []byte, error) {
+	newline := bytes.IndexByte(data, '\n')
+	if reader.skipping {
+		if newline < 0 {
+			return len(data), nil, nil
+		}
+		reader.skipping = false
+		return newline + 1, nil, nil
+	}
+	switch {
+	case newline >= 0:
+		reader.tooLong = false
+		return newline + 1, data[:newline], nil
+	case len(data) >= maxLineLength:
+		reader.tooLong = true
+		reader.skipping = true
+		return len(data), data, nil
+	case atEOF && len(data) > 0:
+		reader.tooLong = false
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Line returns the number of the last line that was read.
+func (reader *Reader) Line() int {
+	return reader.line
+}
+
+// Read returns the next record. At the end of the stream, the error is
+// io.EOF. Lines that cannot be parsed or are longer than 1MB result in
+// a *ReadError, and reading may continue after it.
+func (reader *Reader) Read() (map[string]interface{}, error) {
+	for {
+		text, tooLong, ok := reader.next()
+		if !ok {
+			if err := reader.scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		line := reader.line
+		if tooLong {
+			return nil, &ReadError{Line: line, Message: fmt.Sprintf("line longer than %d bytes", maxLineLength)}
+		}
+		text = strings.TrimRight(text, "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		format := reader.Format
+		if format == ReadAuto {
+			format = detectFormat(text)
+		}
+		if format == ReadConsole {
+			for {
+				next, ok := reader.peek()
+				if !ok || next.tooLong || !strings.HasPrefix(next.text, consoleContinuation) {
+					break
+				}
+				reader.next()
+				text += "\n" + strings.TrimRight(next.text[len(consoleContinuation):], "\r")
+			}
+		}
+		kv, err := reader.parse(format, text)
+		if err != nil {
+			return nil, &ReadError{Line: line, Message: err.Error()}
+		}
+		return kv, nil
+	}
+}
+
+// Replay reads all records and sends them to a filter.
+// It stops at the first error, and returns nil at the end of the stream.
+func (reader *Reader) Replay(filter Filter) error {
+	for {
+		kv, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		filter.Printd(kv)
+	}
+}
+
+func (reader *Reader) next() (string, bool, bool) {
+	next, ok := reader.peek()
+	if !ok {
+		return "", false, false
+	}
+	reader.peeked = nil
+	reader.line++
+	return next.text, next.tooLong, true
+}
+
+func (reader *Reader) peek() (*readerLine, bool) {
+	if reader.peeked == nil {
+		if !reader.scanner.Scan() {
+			return nil, false
+		}
+		reader.peeked = &readerLine{
+			text:    reader.scanner.Text(),
+			tooLong: reader.tooLong,
+		}
+	}
+	return reader.peeked, true
+}
+
+// detectFormat guesses the format of a line.
+func detectFormat(text string) ReadFormat {
+	trimmed := strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		return ReadJson
+	case strings.HasPrefix(trimmed, "[") || strings.Contains(text, " | "):
+		return ReadConsole
+	case hasLogfmtPairs(parseLogfmt(text)):
+		return ReadLogfmt
+	default:
+		return ReadConsole
+	}
+}
+
+func (reader *Reader) parse(format ReadFormat, text string) (map[string]interface{}, error) {
+	switch format {
+	case ReadJson:
+		kv := decodeJsonLine([]byte(text))
+		if kv == nil {
+			return nil, fmt.Errorf("invalid JSON object")
+		}
+		reader.restoreTime(kv)
+		return kv, nil
+	case ReadLogfmt:
+		kv := parseLogfmt(text)
+		reader.restoreTime(kv)
+		return kv, nil
+	default:
+		return reader.parseConsole(text), nil
+	}
+}
+
+// restoreTime converts StdTimeKey into a time.Time, if possible.
+func (reader *Reader) restoreTime(kv map[string]interface{}) {
+	if s, ok := kv[StdTimeKey].(string); ok {
+		if t, err := time.Parse(stringOrDefault(reader.TimeFormat, time.RFC3339Nano), s); err == nil {
+			kv[StdTimeKey] = t
+		}
+	}
+}
+
+// parseConsole parses a line in the [time] message | key: value format.
+func (reader *Reader) parseConsole(text string) map[string]interface{} {
+	kv := make(map[string]interface{})
+	// the time is only recognized in the default format
+	if len(text) > len(ConsoleTimeFormat) && text[0] == '[' && text[len(ConsoleTimeFormat)] == ' ' {
+		location := reader.Location
+		if location == nil {
+			location = time.Local
+		}
+		if t, err := time.ParseInLocation(ConsoleTimeFormat, text[:len(ConsoleTimeFormat)], location); err == nil {
+			kv[StdTimeKey] = t
+			text = text[len(ConsoleTimeFormat)+1:]
+		}
+	}
+	parts := splitConsole(text)
+	kv[StdMessageKey] = strings.TrimRight(parts[0], " ")
+	for _, part := range parts[1:] {
+		colon := strings.Index(part, ": ")
+		if colon < 0 {
+			// a key with an empty value loses its separator when trimmed
+			if key := strings.TrimSuffix(strings.TrimSpace(part), ":"); key != "" {
+				kv[key] = ""
+			}
+			continue
+		}
+		kv[strings.TrimSpace(part[:colon])] = unquoteConsole(strings.TrimSpace(part[colon+2:]))
+	}
+	return kv
+}
+
+// splitConsole splits a console line at " | ", except inside quotes.
+func splitConsole(text string) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quoted && text[i] == '\\':
+			i++
+		case text[i] == '"' && (quoted || i >= 2 && text[i-2:i] == ": "):
+			// quotes only start at the beginning of a value
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(text[i:], " | "):
+			parts = append(parts, text[start:i])
+			start = i + len(" | ")
+			i = start - 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// unquoteConsole removes the quotes added by EscapeQuote.
+func unquoteConsole(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			return unquoted
+		}
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderJson(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	formatter := &JsonFormatter{}
+	t01 := time.Date(2018, 1, 2, 3, 4, 5, 6000, time.UTC)
+	formatter.Formatd(map[string]interface{}{StdTimeKey: t01, StdMessageKey: "first", "count": 3}, buffer)
+	buffer.WriteString("{broken\n")
+	formatter.Formatd(map[string]interface{}{StdMessageKey: "second", "nested": map[string]interface{}{"a": true}}, buffer)
+
+	reader := NewReader(buffer)
+	reader.Format = ReadJson
+	r01, err := reader.Read()
+	if err != nil || !r01[StdTimeKey].(time.Time).Equal(t01) || r01[StdMessageKey] != "first" || r01["count"] != json.Number("3") {
+		t.Errorf("t01: invalid record: %v %v", r01, err)
+	}
+	if _, err := reader.Read(); err == nil || err.(*ReadError).Line != 2 {
+		t.Errorf("t02: expected an error on line 2: %v", err)
+	}
+	r03, err := reader.Read()
+	if err != nil || !reflect.DeepEqual(r03["nested"], map[string]interface{}{"a": true}) {
+		t.Errorf("t03: reading should continue after errors: %v %v", r03, err)
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("t04: expected the end of the stream: %v", err)
+	}
+}
+
+func TestReaderLongLines(t *testing.T) {
+	input := "first\n" + strings.Repeat("x", 3*maxLineLength) + "\nsecond\n" + strings.Repeat("y", maxLineLength+1)
+	reader := NewReader(strings.NewReader(input))
+	if r01, err := reader.Read(); err != nil || r01[StdMessageKey] != "first" {
+		t.Errorf("t01: invalid record: %v %v", r01, err)
+	}
+	if _, err := reader.Read(); err == nil || err.(*ReadError).Line != 2 {
+		t.Errorf("t02: expected an error on line 2: %v", err)
+	}
+	if r03, err := reader.Read(); err != nil || r03[StdMessageKey] != "second" || reader.Line() != 3 {
+		t.Errorf("t03: reading should continue after long lines: %v %v", r03, err)
+	}
+	if _, err := reader.Read(); err == nil || err.(*ReadError).Line != 4 {
+		t.Errorf("t04: expected an error on line 4: %v", err)
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Errorf("t05: expected the end of the stream: %v", err)
+	}
+}
+
+func TestReaderConsole(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	t01 := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	plain := &ConsoleFormatter{PrintTime: true, PrintKeys: true, SortKeys: true}
+	plain.Formatd(map[string]interface{}{StdTimeKey: t01, StdMessageKey: "hello world", "a": 1, "b": "x y"}, buffer)
+	quoted := &ConsoleFormatter{PrintKeys: true, SortKeys: true, Escape: EscapeQuote}
+	quoted.Formatd(map[string]interface{}{StdMessageKey: "quoted", "a": "one | two", "b": "", "c": "say \"hi\""}, buffer)
+	multiline := &ConsoleFormatter{PrintKeys: true, Escape: EscapeMultiline}
+	multiline.Formatd(map[string]interface{}{StdMessageKey: "first\nsecond", "k": "v"}, buffer)
+	columns := &ConsoleFormatter{PrintKeys: true, SortKeys: true, Columns: true, MessageWidth: 10}
+	columns.Formatd(map[string]interface{}{StdMessageKey: "short", "a": "xy", "b": ""}, buffer)
+	columns.Formatd(map[string]interface{}{StdMessageKey: "columns", "b": 1}, buffer)
+
+	reader := NewReader(buffer)
+	reader.Location = time.UTC
+	expected := []map[string]interface{}{
+		{StdTimeKey: t01, StdMessageKey: "hello world", "a": "1", "b": "x y"},
+		{StdMessageKey: "quoted", "a": "one | two", "b": "", "c": "say \"hi\""},
+		{StdMessageKey: "first\nsecond", "k": "v"},
+		{StdMessageKey: "short", "a": "xy", "b": ""},
+		{StdMessageKey: "columns", "b": "1"},
+	}
+	for i, x := range expected {
+		r, err := reader.Read()
+		if err != nil || !reflect.DeepEqual(r, x) {
+			t.Errorf("c%02d: invalid record: %q %v", i+1, r, err)
+		}
+	}
+	if _, err := reader.Read(); err != io.EOF || reader.Line() != 6 {
+		t.Errorf("c06: expected the end of the stream after 6 lines: %v %d", err, reader.Line())
+	}
+}
+
+func TestReaderAuto(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"message":"json","time":"2018-01-02T03:04:05Z"}`,
+		`time=2018-01-02T03:04:05Z level=info msg="logfmt line"`,
+		`[2018-01-02 03:04:05] console | k: v`,
+		`just a message`,
+		"",
+	}, "\n")
+	recorder := &recordFilter{}
+	reader := NewReader(strings.NewReader(stream))
+	if err := reader.Replay(recorder); err != nil || len(recorder.records) != 4 {
+		t.Fatalf("a01: invalid replay: %v %v", recorder.records, err)
+	}
+	if _, ok := recorder.records[1][StdTimeKey].(time.Time); !ok || recorder.records[1]["msg"] != "logfmt line" {
+		t.Errorf("a02: invalid logfmt record: %v", recorder.records[1])
+	}
+	if _, ok := recorder.records[2][StdTimeKey].(time.Time); !ok || recorder.records[2]["k"] != "v" {
+		t.Errorf("a03: invalid console record: %v", recorder.records[2])
+	}
+	if recorder.records[3][StdMessageKey] != "just a message" {
+		t.Errorf("a04: invalid message record: %v", recorder.records[3])
+	}
+}
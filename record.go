@@ -0,0 +1,202 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"io"
+	"sync"
+)
+
+const (
+	// recordFieldAllocation is the initial capacity of the field list of
+	// a pooled record.
+	recordFieldAllocation = 16
+)
+
+var (
+	recordPool = sync.Pool{
+		New: func() interface{} {
+			return &Record{
+				fields: make([]Field, 0, recordFieldAllocation),
+			}
+		},
+	}
+)
+
+// RecordFilter can be implemented by filters that are able to process a
+// Record without converting it into a dictionary.
+//
+// Records are pooled and reused. A RecordFilter must not hold on to a
+// record after Printr returns; if it needs to, it should keep the
+// dictionary returned by Record.Dict instead.
+type RecordFilter interface {
+	Printr(record *Record)
+}
+
+// RecordFormatter can be implemented by formatters that are able to
+// format a Record without converting it into a dictionary.
+type RecordFormatter interface {
+	Formatr(record *Record, sink io.Writer)
+}
+
+// Record is a log record made from typed fields.
+//
+// A record starts out as a list of fields. As soon as a filter or formatter
+// needs a dictionary, Dict converts it into one, and from then on the
+// dictionary holds the contents of the record.
+//
+// Keys should be unique. If a key occurs more than once, the last value
+// wins: It replaces the others when converting to a dictionary, and is the
+// only one that is looked up, set, iterated or encoded.
+type Record struct {
+	fields []Field
+	dict   map[string]interface{}
+}
+
+// newRecord takes a record from the pool and fills it with fields.
+func newRecord(fields []Field) *Record {
+	record := recordPool.Get().(*Record)
+	record.fields = append(record.fields[:0], fields...)
+	return record
+}
+
+// release returns a record to the pool.
+func (record *Record) release() {
+	for i := range record.fields {
+		record.fields[i] = Field{}
+	}
+	record.fields = record.fields[:0]
+	record.dict = nil
+	recordPool.Put(record)
+}
+
+// Dict converts the record into a dictionary and returns it.
+// Modifications of the dictionary are reflected in the record.
+func (record *Record) Dict() map[string]interface{} {
+	if record.dict == nil {
+		record.dict = make(map[string]interface{}, len(record.fields))
+		for _, field := range record.fields {
+			record.dict[field.Key] = field.Value()
+		}
+		record.fields = record.fields[:0]
+	}
+	return record.dict
+}
+
+// Len returns the number of distinct keys in the record.
+func (record *Record) Len() int {
+	if record.dict != nil {
+		return len(record.dict)
+	}
+	n := 0
+	for i := range record.fields {
+		if !record.shadowed(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// Lookup returns the field stored under key.
+// If the record was converted into a dictionary, the value is returned
+// as a FieldAny.
+func (record *Record) Lookup(key string) (Field, bool) {
+	if record.dict != nil {
+		v, ok := record.dict[key]
+		return Any(key, v), ok
+	}
+	for i := len(record.fields) - 1; i >= 0; i-- {
+		if record.fields[i].Key == key {
+			return record.fields[i], true
+		}
+	}
+	return Field{}, false
+}
+
+// Set adds a field or replaces an existing one with the same key.
+func (record *Record) Set(field Field) {
+	if record.dict != nil {
+		record.dict[field.Key] = field.Value()
+		return
+	}
+	for i := len(record.fields) - 1; i >= 0; i-- {
+		if record.fields[i].Key == field.Key {
+			record.fields[i] = field
+			return
+		}
+	}
+	record.fields = append(record.fields, field)
+}
+
+// Each calls fn for each field in the record.
+// If the record was converted into a dictionary, the values are passed
+// as FieldAny, in no particular order.
+func (record *Record) Each(fn func(field Field)) {
+	if record.dict != nil {
+		for k, v := range record.dict {
+			fn(Any(k, v))
+		}
+		return
+	}
+	for i, field := range record.fields {
+		if !record.shadowed(i) {
+			fn(field)
+		}
+	}
+}
+
+// shadowed checks if the field at index i is replaced by a later field
+// with the same key.
+func (record *Record) shadowed(i int) bool {
+	for j := i + 1; j < len(record.fields); j++ {
+		if record.fields[j].Key == record.fields[i].Key {
+			return true
+		}
+	}
+	return false
+}
+
+// printRecord sends a record to a filter, converting it into a dictionary
+// if the filter does not support records.
+func printRecord(filter Filter, record *Record) {
+	switch f := filter.(type) {
+	case nil:
+		// pass
+	case RecordFilter:
+		f.Printr(record)
+	default:
+		f.Printd(record.Dict())
+	}
+}
+
+// formatRecord sends a record to a formatter, converting it into a
+// dictionary if the formatter does not support records.
+func formatRecord(formatter Formatter, record *Record, sink io.Writer) {
+	if f, ok := formatter.(RecordFormatter); ok {
+		f.Formatr(record, sink)
+	} else {
+		formatter.Formatd(record.Dict(), sink)
+	}
+}
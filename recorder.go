@@ -0,0 +1,242 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+const (
+	// RecordedKey marks records sent by a FlightRecorder dump.
+	// Type: bool
+	RecordedKey = "recorded"
+	// DefaultFlightRecorderSize is the number of records kept by
+	// FlightRecorder if neither MaxRecords nor MaxBytes are set.
+	DefaultFlightRecorderSize = 1000
+	// minRecorderCompaction is the number of evicted entries that are
+	// tolerated before the buffer is compacted.
+	minRecorderCompaction = 64
+)
+
+// FlightRecorder keeps the most recent records in memory, for post-mortem
+// debugging. Every record is passed on to Logger unchanged, and a copy is
+// kept in a ring buffer. The buffer is sent to Dump when an error is logged,
+// if DumpOnError is set, or when DumpNow is called.
+//
+// A typical setup records everything, but only sends info and above to
+// the log file, and dumps the details when something goes wrong. Dumps on
+// error only contain the records that Logger did not log, so nothing is
+// written twice:
+//
+//	&FlightRecorder{
+//		MaxRecords:  10000,
+//		DumpOnError: true,
+//		Logger:      &LevelFilter{Threshold: LevelInfo, Logger: file},
+//		Dump:        file,
+//	}
+//
+// Records are stored as dictionaries, so they can be formatted with any
+// Formatter when they are dumped. Dumped records carry RecordedKey.
+// Only the top-level dictionary is copied, so nested values must not be
+// modified after logging.
+type FlightRecorder struct {
+	// MaxRecords is the maximum number of records kept.
+	MaxRecords int
+	// MaxBytes is the maximum size of the kept records, encoded as JSON.
+	// If neither limit is set, DefaultFlightRecorderSize records are kept.
+	MaxBytes int
+	// DumpOnError dumps the buffer after a record with LevelError or above.
+	// Only records with a level that Logger does not enable are dumped,
+	// as reported by its LevelEnabled method. Nothing happens if Dump
+	// is nil.
+	DumpOnError bool
+	// Logger receives all records.
+	Logger Filter
+	// Dump receives the kept records when the buffer is dumped.
+	Dump Filter
+
+	mutex   sync.Mutex
+	entries []recorderEntry
+	first   int
+	bytes   int
+}
+
+// recorderEntry is a record in the ring buffer.
+type recorderEntry struct {
+	kv   map[string]interface{}
+	size int
+	// logged is set if the record was passed on by Logger.
+	logged bool
+}
+
+func (recorder *FlightRecorder) Printd(kv map[string]interface{}) {
+	level, ok := LevelOf(kv[LevelKey])
+	logged := recorder.Logger != nil && (!ok || levelEnabled(recorder.Logger, level))
+	recorder.record(copyDict(kv), logged)
+	if recorder.Logger != nil {
+		recorder.Logger.Printd(kv)
+	}
+	if recorder.DumpOnError && recorder.Dump != nil && ok && level >= LevelError {
+		recorder.dump(recorder.Dump, true)
+	}
+}
+
+// record adds a record to the buffer and evicts the oldest ones if necessary.
+func (recorder *FlightRecorder) record(kv map[string]interface{}, logged bool) {
+	size := 0
+	if recorder.MaxBytes > 0 {
+		state := newJsonState()
+		if (&JsonEncoder{KeyOrder: KeyOrderNone}).encodeDict(state, kv, 0) == nil {
+			size = len(state.buf)
+		}
+		state.release()
+	}
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.entries = append(recorder.entries, recorderEntry{kv, size, logged})
+	recorder.bytes += size
+	maxRecords := recorder.MaxRecords
+	if maxRecords <= 0 && recorder.MaxBytes <= 0 {
+		maxRecords = DefaultFlightRecorderSize
+	}
+	for recorder.first < len(recorder.entries) &&
+		(maxRecords > 0 && len(recorder.entries)-recorder.first > maxRecords ||
+			recorder.MaxBytes > 0 && recorder.bytes > recorder.MaxBytes) {
+		recorder.bytes -= recorder.entries[recorder.first].size
+		recorder.entries[recorder.first] = recorderEntry{}
+		recorder.first++
+	}
+	if recorder.first >= minRecorderCompaction && recorder.first > len(recorder.entries)/2 {
+		n := copy(recorder.entries, recorder.entries[recorder.first:])
+		for i := n; i < len(recorder.entries); i++ {
+			recorder.entries[i] = recorderEntry{}
+		}
+		recorder.entries = recorder.entries[:n]
+		recorder.first = 0
+	}
+}
+
+// take removes all records from the buffer and returns them.
+func (recorder *FlightRecorder) take() []recorderEntry {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	entries := recorder.entries[recorder.first:]
+	recorder.entries = nil
+	recorder.first = 0
+	recorder.bytes = 0
+	return entries
+}
+
+// Len returns the number of records in the buffer.
+func (recorder *FlightRecorder) Len() int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return len(recorder.entries) - recorder.first
+}
+
+// Records returns copies of the records in the buffer, oldest first,
+// without removing them.
+func (recorder *FlightRecorder) Records() []map[string]interface{} {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	records := make([]map[string]interface{}, 0, len(recorder.entries)-recorder.first)
+	for _, entry := range recorder.entries[recorder.first:] {
+		records = append(records, copyDict(entry.kv))
+	}
+	return records
+}
+
+// DumpTo sends all records in the buffer to a filter, oldest first,
+// and clears the buffer. Nothing happens if filter is nil.
+func (recorder *FlightRecorder) DumpTo(filter Filter) {
+	recorder.dump(filter, false)
+}
+
+// DumpNow sends all records in the buffer to Dump and clears the buffer.
+func (recorder *FlightRecorder) DumpNow() {
+	recorder.DumpTo(recorder.Dump)
+}
+
+// dump clears the buffer and sends the records to filter, optionally
+// skipping those that were already logged.
+func (recorder *FlightRecorder) dump(filter Filter, skipLogged bool) {
+	if filter == nil {
+		return
+	}
+	for _, entry := range recorder.take() {
+		if skipLogged && entry.logged {
+			continue
+		}
+		entry.kv[RecordedKey] = true
+		filter.Printd(entry.kv)
+	}
+}
+
+// DumpOnSignal dumps the buffer whenever one of the signals is received,
+// for example syscall.SIGUSR1.
+// Call the returned function to stop listening.
+func (recorder *FlightRecorder) DumpOnSignal(signals ...os.Signal) (stop func()) {
+	notify := make(chan os.Signal, 1)
+	signal.Notify(notify, signals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-notify:
+				recorder.DumpNow()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(notify)
+			close(done)
+		})
+	}
+}
+
+// ServeHTTP writes the records in the buffer as JSON lines, oldest first,
+// without removing them.
+func (recorder *FlightRecorder) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	formatter := &JsonFormatter{
+		JsonEncoder: JsonEncoder{
+			LeadingKeys: StdLeadingKeys,
+		},
+	}
+	for _, kv := range recorder.Records() {
+		formatter.Formatd(kv, writer)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlightRecorder(t *testing.T) {
+	logged := &recordFilter{}
+	dumped := &recordFilter{}
+	f01 := &FlightRecorder{
+		MaxRecords:  3,
+		DumpOnError: true,
+		Logger:      &LevelFilter{Threshold: LevelInfo, Logger: logged},
+		Dump:        dumped,
+	}
+	for i := 0; i < 5; i++ {
+		f01.Printd(map[string]interface{}{LevelKey: LevelDebug, StdMessageKey: fmt.Sprint(i)})
+	}
+	if len(logged.records) != 0 || f01.Len() != 3 {
+		t.Errorf("t01: debug records should only be kept: %d", f01.Len())
+	}
+	f01.Printd(map[string]interface{}{LevelKey: LevelError, StdMessageKey: "failed"})
+	if len(logged.records) != 1 || len(dumped.records) != 2 || f01.Len() != 0 {
+		t.Errorf("t02: the buffer should be dumped on error: %v", dumped.records)
+	}
+	if dumped.records[0][StdMessageKey] != "3" || dumped.records[1][StdMessageKey] != "4" || dumped.records[1][RecordedKey] != true {
+		t.Errorf("t02: only records that were not logged should be dumped: %v", dumped.records)
+	}
+	if _, ok := logged.records[0][RecordedKey]; ok {
+		t.Errorf("t02: passed records should not be modified")
+	}
+
+	f03 := &FlightRecorder{
+		MaxBytes: 100,
+	}
+	for i := 0; i < 100; i++ {
+		f03.Printd(map[string]interface{}{StdMessageKey: fmt.Sprintf("message %02d", i)})
+	}
+	// each record is 25 bytes
+	r03 := f03.Records()
+	if len(r03) != 4 || r03[0][StdMessageKey] != "message 96" || f03.Len() != 4 {
+		t.Errorf("t03: invalid records: %v", r03)
+	}
+	dumped.records = nil
+	f03.DumpTo(dumped)
+	if len(dumped.records) != 4 || f03.Len() != 0 {
+		t.Errorf("t03: the buffer should be dumped")
+	}
+
+	f04 := &FlightRecorder{}
+	for i := 0; i < DefaultFlightRecorderSize+10; i++ {
+		f04.Printd(map[string]interface{}{StdMessageKey: i})
+	}
+	if r04 := f04.Records(); len(r04) != DefaultFlightRecorderSize || r04[0][StdMessageKey] != 10 {
+		t.Errorf("t04: invalid default size: %d", len(r04))
+	}
+
+	f05 := &FlightRecorder{
+		DumpOnError: true,
+	}
+	f05.Printd(map[string]interface{}{LevelKey: LevelDebug, StdMessageKey: "kept"})
+	f05.Printd(map[string]interface{}{LevelKey: LevelError, StdMessageKey: "failed"})
+	f05.DumpNow()
+	if f05.Len() != 2 {
+		t.Errorf("t05: the buffer should be kept without Dump: %d", f05.Len())
+	}
+	dumped.records = nil
+	f05.DumpTo(dumped)
+	if len(dumped.records) != 2 {
+		t.Errorf("t05: explicit dumps should contain all records: %v", dumped.records)
+	}
+}
+
+func TestFlightRecorderHTTP(t *testing.T) {
+	recorder := &FlightRecorder{}
+	recorder.Printd(map[string]interface{}{StdMessageKey: "one", "count": 1})
+	recorder.Printd(map[string]interface{}{StdMessageKey: "two"})
+	response := httptest.NewRecorder()
+	recorder.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	body, _ := ioutil.ReadAll(response.Body)
+	if string(body) != "{\"message\":\"one\",\"count\":1}\n{\"message\":\"two\"}\n" || recorder.Len() != 2 {
+		t.Errorf("t01: invalid response: %s", body)
+	}
+
+	response = httptest.NewRecorder()
+	recorder.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("")))
+	if response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("t02: invalid status: %d", response.Code)
+	}
+}
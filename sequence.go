@@ -0,0 +1,308 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	// SequenceKey is the default key for record sequence numbers.
+	// Type: uint64
+	SequenceKey = "seq"
+	// SessionKey is the default key for the session ID.
+	// Type: string
+	SessionKey = "session"
+	// maxLineLength is the longest log line that will be accepted by stream scanners.
+	maxLineLength = 1024 * 1024
+)
+
+var (
+	// ProcessSession is a random ID that is generated once per process.
+	// It is used by SequenceFilter to tell apart restarts.
+	ProcessSession = newSessionId()
+)
+
+func newSessionId() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+// SequenceFilter stamps each dictionary with a monotonically increasing
+// sequence number and a session ID.
+// The first sequence number is 1.
+//
+// Combined with SequenceChecker, this allows detecting lost, duplicated and
+// reordered records in asynchronous pipelines and network sinks.
+// Existing values are replaced.
+type SequenceFilter struct {
+	// Session is the session ID. Defaults to ProcessSession if unset.
+	Session string
+	// SequenceKey is the key for the sequence number.
+	// Defaults to SequenceKey if unset.
+	SequenceKey string
+	// SessionKey is the key for the session ID.
+	// Defaults to SessionKey if unset.
+	SessionKey string
+
+	counter uint64
+}
+
+func (filter *SequenceFilter) Printd(kv map[string]interface{}) {
+	session := filter.Session
+	if session == "" {
+		session = ProcessSession
+	}
+	kv[stringOrDefault(filter.SequenceKey, SequenceKey)] = atomic.AddUint64(&filter.counter, 1)
+	kv[stringOrDefault(filter.SessionKey, SessionKey)] = session
+}
+
+// SequenceIssueKind identifies the type of problem reported by SequenceChecker.
+type SequenceIssueKind int
+
+const (
+	// SequenceGap means that one or more sequence numbers were skipped.
+	SequenceGap SequenceIssueKind = iota
+	// SequenceDuplicate means that a sequence number was seen more than once.
+	SequenceDuplicate
+	// SequenceReorder means that a skipped sequence number arrived late.
+	SequenceReorder
+)
+
+func (kind SequenceIssueKind) String() string {
+	switch kind {
+	case SequenceGap:
+		return "gap"
+	case SequenceDuplicate:
+		return "duplicate"
+	case SequenceReorder:
+		return "reorder"
+	default:
+		return "unknown"
+	}
+}
+
+// SequenceIssue describes a problem found by SequenceChecker.
+type SequenceIssue struct {
+	Kind    SequenceIssueKind
+	Session string
+	// First and Last are the affected range of sequence numbers.
+	// They are equal for duplicates and reorders.
+	First uint64
+	Last  uint64
+	// Line is the line number in the stream, if the record was read
+	// by Scan. Starts at 1.
+	Line int
+}
+
+func (issue SequenceIssue) String() string {
+	var where string
+	if issue.Line > 0 {
+		where = fmt.Sprintf("line %d: ", issue.Line)
+	}
+	if issue.First == issue.Last {
+		return fmt.Sprintf("%s%s in session %s: %d", where, issue.Kind, issue.Session, issue.First)
+	}
+	return fmt.Sprintf("%s%s in session %s: %d-%d", where, issue.Kind, issue.Session, issue.First, issue.Last)
+}
+
+// sequenceRange is an inclusive range of sequence numbers.
+type sequenceRange struct {
+	first, last uint64
+}
+
+type sequenceState struct {
+	// first is the lowest sequence number seen.
+	first   uint64
+	next    uint64
+	missing []sequenceRange
+}
+
+// take removes seq from the missing list, and returns true if it was missing.
+func (state *sequenceState) take(seq uint64) bool {
+	for i, r := range state.missing {
+		if seq < r.first || seq > r.last {
+			continue
+		}
+		switch {
+		case r.first == r.last:
+			state.missing = append(state.missing[:i], state.missing[i+1:]...)
+		case seq == r.first:
+			state.missing[i].first++
+		case seq == r.last:
+			state.missing[i].last--
+		default:
+			state.missing = append(state.missing, sequenceRange{seq + 1, r.last})
+			state.missing[i].last = seq - 1
+		}
+		return true
+	}
+	return false
+}
+
+// SequenceChecker analyzes the sequence numbers produced by SequenceFilter
+// and reports gaps, duplicates and reordering.
+//
+// Each session is tracked separately. The first record of a session
+// determines the initial sequence number, so streams may start anywhere.
+// Records that arrive later with a lower number are reported as reordered,
+// and the numbers between them and the initial one are expected as well.
+type SequenceChecker struct {
+	// SequenceKey is the key for the sequence number.
+	// Defaults to SequenceKey if unset.
+	SequenceKey string
+	// SessionKey is the key for the session ID.
+	// Defaults to SessionKey if unset.
+	SessionKey string
+
+	sessions map[string]*sequenceState
+}
+
+// Check examines a single dictionary and returns the issues found.
+// Dictionaries without a valid sequence number or without a session ID
+// are ignored.
+func (checker *SequenceChecker) Check(kv map[string]interface{}) []SequenceIssue {
+	seq, ok := toUint64(kv[stringOrDefault(checker.SequenceKey, SequenceKey)])
+	if !ok {
+		return nil
+	}
+	id, ok := kv[stringOrDefault(checker.SessionKey, SessionKey)]
+	if !ok || id == nil {
+		return nil
+	}
+	session := fmt.Sprint(id)
+	if checker.sessions == nil {
+		checker.sessions = make(map[string]*sequenceState)
+	}
+	state, ok := checker.sessions[session]
+	if !ok {
+		checker.sessions[session] = &sequenceState{
+			first: seq,
+			next:  seq + 1,
+		}
+		return nil
+	}
+	switch {
+	case seq < state.first:
+		// the stream started earlier than the first record suggested
+		if seq+1 < state.first {
+			state.missing = append(state.missing, sequenceRange{seq + 1, state.first - 1})
+		}
+		state.first = seq
+		return []SequenceIssue{{Kind: SequenceReorder, Session: session, First: seq, Last: seq}}
+	case seq == state.next:
+		state.next++
+		return nil
+	case seq > state.next:
+		gap := sequenceRange{state.next, seq - 1}
+		state.missing = append(state.missing, gap)
+		state.next = seq + 1
+		return []SequenceIssue{{Kind: SequenceGap, Session: session, First: gap.first, Last: gap.last}}
+	case state.take(seq):
+		return []SequenceIssue{{Kind: SequenceReorder, Session: session, First: seq, Last: seq}}
+	default:
+		return []SequenceIssue{{Kind: SequenceDuplicate, Session: session, First: seq, Last: seq}}
+	}
+}
+
+// Missing returns the issues for all gaps that have not been filled by
+// reordered records. Call it after the last record was checked.
+func (checker *SequenceChecker) Missing() []SequenceIssue {
+	var issues []SequenceIssue
+	for _, session := range orderedSessions(checker.sessions) {
+		for _, r := range checker.sessions[session].missing {
+			issues = append(issues, SequenceIssue{Kind: SequenceGap, Session: session, First: r.first, Last: r.last})
+		}
+	}
+	return issues
+}
+
+// Scan reads a stream of JSON or logfmt records, one per line, and calls
+// report for each issue found. Lines starting with '{' are treated as JSON,
+// everything else as logfmt. Lines that cannot be parsed are skipped.
+func (checker *SequenceChecker) Scan(stream io.Reader, report func(SequenceIssue)) error {
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(nil, maxLineLength)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		var kv map[string]interface{}
+		if len(text) > 0 && text[0] == '{' {
+			if kv = decodeJsonLine(text); kv == nil {
+				continue
+			}
+		} else {
+			kv = parseLogfmt(string(text))
+		}
+		for _, issue := range checker.Check(kv) {
+			issue.Line = line
+			report(issue)
+		}
+	}
+	return scanner.Err()
+}
+
+func orderedSessions(sessions map[string]*sequenceState) []string {
+	m := make(map[string]interface{}, len(sessions))
+	for k := range sessions {
+		m[k] = nil
+	}
+	return OrderedStringKeys(m)
+}
+
+// toUint64 converts the numeric representations produced by SequenceFilter
+// and the various decoders to uint64.
+func toUint64(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return n, true
+	case int:
+		return uint64(n), n >= 0
+	case int64:
+		return uint64(n), n >= 0
+	case float64:
+		return uint64(n), n >= 0
+	case json.Number:
+		u, err := strconv.ParseUint(string(n), 10, 64)
+		return u, err == nil
+	case string:
+		u, err := strconv.ParseUint(n, 10, 64)
+		return u, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSequenceFilter(t *testing.T) {
+	c01a := map[string]interface{}{}
+	c01b := map[string]interface{}{}
+	f01 := &SequenceFilter{}
+	f01.Printd(c01a)
+	f01.Printd(c01b)
+	if c01a[SequenceKey] != uint64(1) || c01b[SequenceKey] != uint64(2) {
+		t.Errorf("t01: invalid sequence numbers: %v %v", c01a, c01b)
+	}
+	if c01a[SessionKey] != ProcessSession || ProcessSession == "" {
+		t.Errorf("t01: invalid session: %v", c01a)
+	}
+
+	c02 := map[string]interface{}{}
+	f02 := &SequenceFilter{
+		Session:     "test02",
+		SequenceKey: "n",
+		SessionKey:  "s",
+	}
+	f02.Printd(c02)
+	if len(c02) != 2 || c02["n"] != uint64(1) || c02["s"] != "test02" {
+		t.Errorf("t02: custom keys were not used: %v", c02)
+	}
+}
+
+func TestSequenceChecker(t *testing.T) {
+	c01 := &SequenceChecker{}
+	var r01 []SequenceIssue
+	for _, seq := range []uint64{5, 6, 9, 7, 7, 10, 10} {
+		r01 = append(r01, c01.Check(map[string]interface{}{SequenceKey: seq, SessionKey: "a"})...)
+	}
+	x01 := []SequenceIssue{
+		{Kind: SequenceGap, Session: "a", First: 7, Last: 8},
+		{Kind: SequenceReorder, Session: "a", First: 7, Last: 7},
+		{Kind: SequenceDuplicate, Session: "a", First: 7, Last: 7},
+		{Kind: SequenceDuplicate, Session: "a", First: 10, Last: 10},
+	}
+	if !reflect.DeepEqual(r01, x01) {
+		t.Errorf("t01: invalid issues: %v", r01)
+	}
+	m01 := c01.Missing()
+	if len(m01) != 1 || m01[0].First != 8 || m01[0].Last != 8 {
+		t.Errorf("t01: invalid missing ranges: %v", m01)
+	}
+
+	c02 := &SequenceChecker{}
+	q02 := `{"message":"a","seq":1,"session":"x"}
+{"message":"b","seq":3,"session":"x"}
+message=c seq=1 session=y
+message="d e" seq=2 session=y
+not a record
+{"message":"f","seq":2,"session":"x"}
+`
+	var r02 []string
+	err := c02.Scan(strings.NewReader(q02), func(issue SequenceIssue) {
+		r02 = append(r02, issue.String())
+	})
+	x02 := []string{
+		"line 2: gap in session x: 2",
+		"line 6: reorder in session x: 2",
+	}
+	if err != nil || !reflect.DeepEqual(r02, x02) {
+		t.Errorf("t02: invalid issues: %v %v", r02, err)
+	}
+	if m02 := c02.Missing(); len(m02) != 0 {
+		t.Errorf("t02: unexpected missing ranges: %v", m02)
+	}
+
+	c03 := &SequenceChecker{}
+	var r03 []SequenceIssue
+	for _, seq := range []uint64{5, 2, 2, 4} {
+		r03 = append(r03, c03.Check(map[string]interface{}{SequenceKey: seq, SessionKey: "a"})...)
+	}
+	r03 = append(r03, c03.Check(map[string]interface{}{SequenceKey: uint64(1)})...)
+	r03 = append(r03, c03.Check(map[string]interface{}{SequenceKey: uint64(9)})...)
+	x03 := []SequenceIssue{
+		{Kind: SequenceReorder, Session: "a", First: 2, Last: 2},
+		{Kind: SequenceDuplicate, Session: "a", First: 2, Last: 2},
+		{Kind: SequenceReorder, Session: "a", First: 4, Last: 4},
+	}
+	if !reflect.DeepEqual(r03, x03) {
+		t.Errorf("t03: invalid issues: %v", r03)
+	}
+	m03 := c03.Missing()
+	if len(m03) != 1 || m03[0].First != 3 || m03[0].Last != 3 || len(c03.sessions) != 1 {
+		t.Errorf("t03: invalid missing ranges: %v", m03)
+	}
+}
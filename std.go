@@ -55,13 +55,13 @@ func (logger *StdLogger) Print(v ...interface{}) {
 // Println is simply an alias for Print, as log messages are always terminated with a newline.
 // Provided for compatibility with log.Logger.
 func (logger *StdLogger) Println(v ...interface{}) {
-	logger.Print(v)
+	logger.Print(v...)
 }
 
 // Printf formats a string like log.Printf does, then logs it as a single
 // log line.
 func (logger *StdLogger) Printf(format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v)
+	message := fmt.Sprintf(format, v...)
 	logger.Print(message)
 }
 
@@ -72,3 +72,26 @@ func (logger *StdLogger) Printkv(kv ...interface{}) {
 	mkv := SliceToMap(kv)
 	logger.Printd(mkv)
 }
+
+// Printr packs a list of typed fields into a Record and sends it to the
+// Filter chain. If the list contains a level field and the chain reports
+// that level as disabled, nothing is allocated.
+//
+// This is the fast alternative to Printkv:
+//
+//	logger.Printr(kvl.Lvl(kvl.LevelDebug), kvl.String("message", "hello"), kvl.Int("count", 99))
+func (logger *StdLogger) Printr(fields ...Field) {
+	if logger.Logger == nil {
+		return
+	}
+	for i := range fields {
+		if fields[i].Key == LevelKey {
+			if level, ok := fields[i].level(); ok && !levelEnabled(logger.Logger, level) {
+				return
+			}
+		}
+	}
+	record := newRecord(fields)
+	printRecord(logger.Logger, record)
+	record.release()
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	b01 := &bytes.Buffer{}
+	l01 := &StdLogger{
+		Logger: &Logger{
+			Formatter: &ConsoleFormatter{},
+			Sink:      b01,
+		},
+	}
+	l01.Printf("%d of %s", 3, "test01")
+	if b01.String() != "3 of test01\n" {
+		t.Errorf("t01: arguments were not formatted: %q", b01.String())
+	}
+
+	b02 := &bytes.Buffer{}
+	l02 := &StdLogger{
+		Logger: &Logger{
+			Formatter: &ConsoleFormatter{},
+			Sink:      b02,
+		},
+	}
+	l02.Println("test02a", "test02b")
+	if b02.String() != "test02a\ntest02b\n" {
+		t.Errorf("t02: each argument should be logged separately: %q", b02.String())
+	}
+}
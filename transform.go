@@ -0,0 +1,125 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+// TransformOp is an operation applied by TransformFilter.
+type TransformOp int
+
+const (
+	// TransformRename moves the first present key of Keys to Target.
+	TransformRename TransformOp = iota
+	// TransformCopy copies the first present key of Keys to Target.
+	TransformCopy
+	// TransformDelete removes all Keys.
+	TransformDelete
+	// TransformKeep removes all keys that are not in Keys.
+	TransformKeep
+	// TransformNest moves all present Keys into a nested dictionary
+	// stored under Target.
+	TransformNest
+	// TransformDefault sets all Keys that are not present to Value.
+	TransformDefault
+)
+
+// TransformRule is a single rule of a TransformFilter.
+type TransformRule struct {
+	// Op is the operation to apply.
+	Op TransformOp
+	// Keys are the keys the operation applies to.
+	Keys []string
+	// Target is the destination key for TransformRename, TransformCopy
+	// and TransformNest.
+	Target string
+	// Value is the value set by TransformDefault.
+	Value interface{}
+}
+
+// TransformFilter renames, copies, deletes and restructures keys according
+// to a list of rules, which are applied in order.
+//
+// This is useful to adapt records to the schema expected by a downstream
+// system, for example by renaming StdMessageKey to "msg".
+// When feeding several systems with different schemas, place each
+// TransformFilter in its own branch, as the dictionary is modified in-place.
+//
+// If the target of TransformNest exists, but is not a
+// map[string]interface{}, it will be replaced.
+type TransformFilter struct {
+	Rules []TransformRule
+}
+
+func (filter *TransformFilter) Printd(kv map[string]interface{}) {
+	for _, rule := range filter.Rules {
+		switch rule.Op {
+		case TransformRename:
+			for _, k := range rule.Keys {
+				if v, ok := kv[k]; ok {
+					delete(kv, k)
+					kv[rule.Target] = v
+					break
+				}
+			}
+		case TransformCopy:
+			for _, k := range rule.Keys {
+				if v, ok := kv[k]; ok {
+					kv[rule.Target] = v
+					break
+				}
+			}
+		case TransformDelete:
+			for _, k := range rule.Keys {
+				delete(kv, k)
+			}
+		case TransformKeep:
+			for k := range kv {
+				if !containsString(rule.Keys, k) {
+					delete(kv, k)
+				}
+			}
+		case TransformNest:
+			existing, _ := kv[rule.Target].(map[string]interface{})
+			var nested map[string]interface{}
+			for _, k := range rule.Keys {
+				if v, ok := kv[k]; ok && k != rule.Target {
+					if nested == nil {
+						// the existing map may be shared with other records
+						nested = copyDict(existing)
+					}
+					nested[k] = v
+					delete(kv, k)
+				}
+			}
+			if nested != nil {
+				kv[rule.Target] = nested
+			}
+		case TransformDefault:
+			for _, k := range rule.Keys {
+				if _, ok := kv[k]; !ok {
+					kv[k] = rule.Value
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2018, Gregor Riepl <onitake@gmail.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+//    1. Redistributions of source code must retain the above copyright notice, this list of
+//       conditions and the following disclaimer.
+//
+//    2. Redistributions in binary form must reproduce the above copyright notice, this list
+//       of conditions and the following disclaimer in the documentation and/or other materials
+//       provided with the distribution.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+// ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+// WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL <COPYRIGHT HOLDER> BE LIABLE FOR ANY
+// DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+// (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+// LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+// ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+// (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+// SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package kvl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func transformTest(t *testing.T, testno string, rules []TransformRule, query map[string]interface{}, expected map[string]interface{}) {
+	testee := &TransformFilter{
+		Rules: rules,
+	}
+	testee.Printd(query)
+	if !reflect.DeepEqual(query, expected) {
+		t.Errorf("%s: no match. expected: '%v' got: '%v'", testno, expected, query)
+	}
+}
+
+func TestTransformFilter(t *testing.T) {
+	transformTest(t, "t01", nil,
+		map[string]interface{}{"message": "test01"},
+		map[string]interface{}{"message": "test01"})
+
+	transformTest(t, "t02", []TransformRule{
+		{Op: TransformRename, Keys: []string{"message"}, Target: "msg"},
+		{Op: TransformRename, Keys: []string{"missing", "time"}, Target: "ts"},
+	},
+		map[string]interface{}{"message": "test02", "time": 2},
+		map[string]interface{}{"msg": "test02", "ts": 2})
+
+	transformTest(t, "t03", []TransformRule{
+		{Op: TransformCopy, Keys: []string{"message"}, Target: "msg"},
+	},
+		map[string]interface{}{"message": "test03"},
+		map[string]interface{}{"message": "test03", "msg": "test03"})
+
+	transformTest(t, "t04", []TransformRule{
+		{Op: TransformDelete, Keys: []string{"a", "b", "missing"}},
+	},
+		map[string]interface{}{"message": "test04", "a": 1, "b": 2},
+		map[string]interface{}{"message": "test04"})
+
+	transformTest(t, "t05", []TransformRule{
+		{Op: TransformKeep, Keys: []string{"message", "a"}},
+	},
+		map[string]interface{}{"message": "test05", "a": 1, "b": 2, "c": 3},
+		map[string]interface{}{"message": "test05", "a": 1})
+
+	transformTest(t, "t06", []TransformRule{
+		{Op: TransformNest, Keys: []string{"method", "path", "missing"}, Target: "http"},
+	},
+		map[string]interface{}{"message": "test06", "method": "GET", "path": "/"},
+		map[string]interface{}{"message": "test06", "http": map[string]interface{}{"method": "GET", "path": "/"}})
+
+	transformTest(t, "t07", []TransformRule{
+		{Op: TransformNest, Keys: []string{"path"}, Target: "http"},
+	},
+		map[string]interface{}{"message": "test07", "http": map[string]interface{}{"method": "GET"}, "path": "/"},
+		map[string]interface{}{"message": "test07", "http": map[string]interface{}{"method": "GET", "path": "/"}})
+
+	transformTest(t, "t08", []TransformRule{
+		{Op: TransformNest, Keys: []string{"missing"}, Target: "http"},
+	},
+		map[string]interface{}{"message": "test08"},
+		map[string]interface{}{"message": "test08"})
+
+	transformTest(t, "t09", []TransformRule{
+		{Op: TransformDefault, Keys: []string{"message", "level"}, Value: "info"},
+	},
+		map[string]interface{}{"message": "test09"},
+		map[string]interface{}{"message": "test09", "level": "info"})
+
+	h10 := map[string]interface{}{"method": "GET"}
+	b10 := &BranchFilter{
+		Loggers: []Filter{
+			&TransformFilter{
+				Rules: []TransformRule{{Op: TransformNest, Keys: []string{"path"}, Target: "http"}},
+			},
+			&recordFilter{},
+		},
+	}
+	b10.Printd(map[string]interface{}{"message": "test10", "http": h10, "path": "/"})
+	r10 := b10.Loggers[1].(*recordFilter)
+	if len(h10) != 1 || len(r10.records) != 1 || len(r10.records[0]["http"].(map[string]interface{})) != 1 {
+		t.Errorf("t10: shared nested maps should not be modified: %v %v", h10, r10.records)
+	}
+}
@@ -67,3 +67,30 @@ func OrderedStringKeys(m map[string]interface{}) []string {
 	sort.Strings(keys)
 	return keys
 }
+
+// copyDict creates a shallow copy of a dictionary.
+func copyDict(kv map[string]interface{}) map[string]interface{} {
+	dup := make(map[string]interface{}, len(kv))
+	for k, v := range kv {
+		dup[k] = v
+	}
+	return dup
+}
+
+// containsString checks if a string is contained in a list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// stringOrDefault returns s, or def if s is empty.
+func stringOrDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}